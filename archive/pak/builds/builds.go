@@ -0,0 +1,266 @@
+// Package builds identifies known game builds of PAK archives by the SHA1 hashes
+// of the files they contain.
+//
+// The database of known builds records the SHA1 hash of files by default path
+// (e.g. "X/tilesets/archive_0016.pak"), so archive trees must be named using
+// the listfile to be identified. Builds may be recorded partially; only the
+// recorded files of a build are compared.
+//
+// No database of game releases is shipped with the package, as fingerprints of
+// entire releases must be generated from the release data (see pak_ident -gen).
+// The shipped database (see ZelPatch) records the files touched by zel_patch
+// only, and thus detects whether zel_patch has been applied to an archive, not
+// which release the archive is from.
+//
+// Example database:
+//
+//	{
+//		"builds": [
+//			{
+//				"name": "unpatched",
+//				"description": "…",
+//				"files": {
+//					"X/tilesets/archive_0016.pak": "776a9f27489da08bcd85b654eaf0474f90994449",
+//					…
+//				}
+//			}
+//		]
+//	}
+package builds
+
+import (
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/mewkiz/pkg/jsonutil"
+	"github.com/mewspring/pak/archive/pak/pakfs"
+	"github.com/pkg/errors"
+)
+
+// zelPatchJSON is the database of zel_patch states (JSON format).
+//
+//go:embed zel_patch.json
+var zelPatchJSON []byte
+
+// DB is a database of known builds.
+type DB struct {
+	// Known builds.
+	Builds []*Build `json:"builds"`
+}
+
+// Build is a known build of a PAK archive.
+type Build struct {
+	// Build name (e.g. "unpatched").
+	Name string `json:"name"`
+	// Human-readable description of the build.
+	Description string `json:"description,omitempty"`
+	// SHA1 hash of file contents (hex encoded) by default path.
+	Files map[string]string `json:"files"`
+}
+
+// ZelPatch returns the database of zel_patch states shipped with the package;
+// the builds "unpatched" and "patched" record the files touched by zel_patch,
+// before and after applying zel_patch respectively. Other files are not
+// recorded.
+func ZelPatch() (*DB, error) {
+	db := &DB{}
+	if err := json.Unmarshal(zelPatchJSON, db); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return db, nil
+}
+
+// ParseFile parses the given database of known builds (JSON format).
+func ParseFile(dbPath string) (*DB, error) {
+	db := &DB{}
+	if err := jsonutil.ParseFile(dbPath, db); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return db, nil
+}
+
+// File is a file of an archive tree.
+type File struct {
+	// File path (e.g. "X/tilesets/tileset_4_buildings.zel").
+	Path string `json:"path"`
+	// Default path (e.g. "X/tilesets/archive_0016.pak").
+	RawPath string `json:"raw_path"`
+	// SHA1 hash of file contents (hex encoded).
+	SHA1 string `json:"sha1"`
+}
+
+// Fingerprint returns the files of the given archive tree, with the SHA1 hash
+// of their contents, sorted by default path. Subarchives exposed as
+// directories are not hashed themselves, only the files they contain.
+func Fingerprint(fsys *pakfs.FS) ([]*File, error) {
+	var files []*File
+	walk := func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		e, ok := info.Sys().(*pakfs.Entry)
+		if !ok {
+			return errors.Errorf("unable to locate PAK entry of %q", filePath)
+		}
+		sr, err := e.Open()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		h := sha1.New()
+		if _, err := io.Copy(h, sr); err != nil {
+			return errors.WithStack(err)
+		}
+		f := &File{
+			Path:    e.Path,
+			RawPath: e.RawPath,
+			SHA1:    hex.EncodeToString(h.Sum(nil)),
+		}
+		files = append(files, f)
+		return nil
+	}
+	if err := fs.WalkDir(fsys, ".", walk); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].RawPath < files[j].RawPath
+	})
+	return files, nil
+}
+
+// NewBuild returns a build of the given name recording the given files.
+func NewBuild(name string, files []*File) *Build {
+	b := &Build{
+		Name:  name,
+		Files: make(map[string]string),
+	}
+	for _, f := range files {
+		b.Files[f.RawPath] = f.SHA1
+	}
+	return b
+}
+
+// Result is the result of identifying the build of a PAK archive.
+type Result struct {
+	// Known builds with at least one matching file, sorted by number of
+	// matching files in descending order.
+	Builds []*BuildMatch `json:"builds"`
+	// Files recorded by a known build, but matching no known build.
+	Unknown []*File `json:"unknown"`
+	// Files not recorded by any known build.
+	Unrecorded []*File `json:"unrecorded"`
+}
+
+// BuildMatch records how the files of a PAK archive match a known build.
+type BuildMatch struct {
+	// Build name.
+	Name string `json:"name"`
+	// Number of recorded files with matching hash.
+	Matched int `json:"matched"`
+	// Number of recorded files with differing hash.
+	Mismatched int `json:"mismatched"`
+	// Number of recorded files not present in the archive.
+	Missing int `json:"missing"`
+}
+
+// Exact reports whether every recorded file of the build is present in the
+// archive with matching hash.
+func (m *BuildMatch) Exact() bool {
+	return m.Matched > 0 && m.Mismatched == 0 && m.Missing == 0
+}
+
+// Identify identifies the build of the PAK archive containing the given files.
+func (db *DB) Identify(files []*File) *Result {
+	result := &Result{}
+	present := make(map[string]bool)
+	matches := make(map[string]*BuildMatch)
+	for _, f := range files {
+		present[f.RawPath] = true
+		recorded, matched := false, false
+		for _, b := range db.Builds {
+			hash, ok := b.Files[f.RawPath]
+			if !ok {
+				continue
+			}
+			recorded = true
+			m, ok := matches[b.Name]
+			if !ok {
+				m = &BuildMatch{Name: b.Name}
+				matches[b.Name] = m
+			}
+			if hash == f.SHA1 {
+				matched = true
+				m.Matched++
+			} else {
+				m.Mismatched++
+			}
+		}
+		switch {
+		case !recorded:
+			result.Unrecorded = append(result.Unrecorded, f)
+		case !matched:
+			result.Unknown = append(result.Unknown, f)
+		}
+	}
+	for _, b := range db.Builds {
+		m, ok := matches[b.Name]
+		if !ok || m.Matched == 0 {
+			continue
+		}
+		for rawPath := range b.Files {
+			if !present[rawPath] {
+				m.Missing++
+			}
+		}
+		result.Builds = append(result.Builds, m)
+	}
+	sort.SliceStable(result.Builds, func(i, j int) bool {
+		return result.Builds[i].Matched > result.Builds[j].Matched
+	})
+	return result
+}
+
+// Is reports whether the archive was identified as the given build; i.e. every
+// recorded file of the build is present with matching hash.
+func (r *Result) Is(name string) bool {
+	for _, m := range r.Builds {
+		if m.Name == name {
+			return m.Exact()
+		}
+	}
+	return false
+}
+
+// Verdict returns a human-readable verdict of the build identification; the
+// name of the build if exactly matched (e.g. "unpatched"), "mix of" followed by
+// the names of builds if every recorded file matches some build but no single
+// build, and "unknown" otherwise.
+func (r *Result) Verdict() string {
+	var exact, names []string
+	for _, m := range r.Builds {
+		if m.Exact() {
+			exact = append(exact, m.Name)
+		}
+		names = append(names, m.Name)
+	}
+	switch {
+	case len(exact) > 0:
+		return strings.Join(exact, ", ")
+	case len(r.Unknown) == 0 && len(names) > 1:
+		return "mix of " + strings.Join(names, ", ")
+	default:
+		return "unknown"
+	}
+}
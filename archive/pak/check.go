@@ -0,0 +1,339 @@
+package pak
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Kind is the kind of a diagnostic.
+type Kind uint8
+
+// Diagnostic kinds.
+const (
+	// Invalid PAK header size; less than 8 bytes or larger than the archive.
+	KindHeaderSize Kind = iota + 1
+	// PAK header size not a multiple of 4 bytes.
+	KindHeaderAlign
+	// End offset of file less than its start offset.
+	KindNonMonotonic
+	// File overlapping the PAK header or a preceding file.
+	KindOverlap
+	// Bytes of the archive not contained within any file.
+	KindGap
+	// File extending past the end of the archive.
+	KindOutOfBounds
+	// Zero-length file.
+	KindEmpty
+)
+
+// String returns the string representation of the diagnostic kind.
+func (kind Kind) String() string {
+	m := map[Kind]string{
+		KindHeaderSize:   "header-size",
+		KindHeaderAlign:  "header-align",
+		KindNonMonotonic: "non-monotonic",
+		KindOverlap:      "overlap",
+		KindGap:          "gap",
+		KindOutOfBounds:  "out-of-bounds",
+		KindEmpty:        "empty",
+	}
+	if s, ok := m[kind]; ok {
+		return s
+	}
+	return fmt.Sprintf("Kind(%d)", uint8(kind))
+}
+
+// MarshalText returns the textual representation of the diagnostic kind.
+func (kind Kind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
+}
+
+// Severity is the severity of a diagnostic.
+type Severity uint8
+
+// Diagnostic severities.
+const (
+	// Damaged archive.
+	SeverityError Severity = iota + 1
+	// Suspicious but well-formed archive; e.g. zero-length files, which are
+	// present in released archives.
+	SeverityWarning
+)
+
+// String returns the string representation of the severity.
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", uint8(severity))
+}
+
+// MarshalText returns the textual representation of the severity.
+func (severity Severity) MarshalText() ([]byte, error) {
+	return []byte(severity.String()), nil
+}
+
+// Diagnostic is an integrity problem of a PAK archive.
+type Diagnostic struct {
+	// Kind of problem.
+	Kind Kind `json:"kind"`
+	// Severity of problem.
+	Severity Severity `json:"severity"`
+	// Indices of the nested subarchives containing the problem, starting from
+	// the top-level archive; empty for problems of the top-level archive.
+	Archive []int `json:"archive"`
+	// Index of the file within its archive, or -1 for problems of the archive
+	// itself.
+	Index int `json:"index"`
+	// Start and end offset in bytes of the problem, relative to the start of
+	// the top-level archive.
+	Offset int64 `json:"offset"`
+	End    int64 `json:"end"`
+	// Human-readable description of the problem.
+	Msg string `json:"msg"`
+	// Underlying error (e.g. ErrBadOffset); nil for gaps and zero-length files.
+	Err error `json:"-"`
+}
+
+// ArchivePath returns the slash-separated indices of the nested subarchives
+// containing the problem (e.g. "3/1"), or "." for the top-level archive.
+func (d *Diagnostic) ArchivePath() string {
+	if len(d.Archive) == 0 {
+		return "."
+	}
+	var indices []string
+	for _, index := range d.Archive {
+		indices = append(indices, strconv.Itoa(index))
+	}
+	return strings.Join(indices, "/")
+}
+
+// Error returns the error message of the diagnostic.
+func (d *Diagnostic) Error() string {
+	return d.String()
+}
+
+// Unwrap returns the underlying error.
+func (d *Diagnostic) Unwrap() error {
+	return d.Err
+}
+
+// String returns the string representation of the diagnostic.
+func (d *Diagnostic) String() string {
+	loc := fmt.Sprintf("archive %s", d.ArchivePath())
+	if d.Index != -1 {
+		loc += fmt.Sprintf(" file %d", d.Index)
+	}
+	return fmt.Sprintf("%s: %s: %s [0x%08X, 0x%08X): %s", loc, d.Severity, d.Kind, d.Offset, d.End, d.Msg)
+}
+
+// Checker checks the integrity of PAK archives.
+type Checker struct {
+	// Descend, if non-nil, reports whether to check the given file as a PAK
+	// subarchive. It is only invoked for files which look like (possibly
+	// damaged) PAK archives, and may be used to exclude file formats sharing the
+	// layout of the PAK header (e.g. ZEL images).
+	Descend func(r io.ReaderAt, size int64) bool
+}
+
+// Check checks the integrity of the given PAK archive of the specified size,
+// and recursively of the subarchives it contains. All problems found are
+// returned, sorted by offset.
+func Check(r io.ReaderAt, size int64) ([]*Diagnostic, error) {
+	c := &Checker{}
+	return c.Check(r, size)
+}
+
+// Check checks the integrity of the given PAK archive of the specified size,
+// and recursively of the subarchives it contains. All problems found are
+// returned, sorted by offset. An error is only returned if reading the archive
+// fails.
+func (c *Checker) Check(r io.ReaderAt, size int64) ([]*Diagnostic, error) {
+	var diags []*Diagnostic
+	if err := c.check(&diags, r, size, nil, 0); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Offset < diags[j].Offset
+	})
+	return diags, nil
+}
+
+// entry is a file of an archive being checked, with offsets relative to the
+// start of the archive.
+type entry struct {
+	index      int
+	start, end int64
+}
+
+// check checks the given PAK archive of the specified size, located at the
+// given offset within the top-level archive and at the given subarchive indices,
+// and appends problems found to diags.
+func (c *Checker) check(diags *[]*Diagnostic, r io.ReaderAt, size int64, archive []int, base int64) error {
+	report := func(kind Kind, index int, start, end int64, format string, args ...interface{}) *Diagnostic {
+		severity := SeverityError
+		if kind == KindEmpty {
+			severity = SeverityWarning
+		}
+		var err error
+		switch kind {
+		case KindHeaderSize, KindHeaderAlign:
+			err = ErrBadHeaderSize
+		case KindNonMonotonic, KindOverlap, KindOutOfBounds:
+			err = ErrBadOffset
+		}
+		d := &Diagnostic{
+			Kind:     kind,
+			Severity: severity,
+			Archive:  archive,
+			Index:    index,
+			Offset:   base + start,
+			End:      base + end,
+			Msg:      fmt.Sprintf(format, args...),
+			Err:      err,
+		}
+		*diags = append(*diags, d)
+		return d
+	}
+	// check PAK header size.
+	if size < 4 {
+		d := report(KindHeaderSize, -1, 0, size, "too short PAK header; expected >= 4, got %d", size)
+		d.Err = ErrTruncated
+		return nil
+	}
+	var rawHdrSize [4]byte
+	if _, err := r.ReadAt(rawHdrSize[:], 0); err != nil {
+		return errors.WithStack(err)
+	}
+	pakHdrSize := int64(binary.LittleEndian.Uint32(rawHdrSize[:]))
+	if pakHdrSize < 8 || pakHdrSize > size {
+		report(KindHeaderSize, -1, 0, 4, "invalid PAK header size; expected >= 8 and <= %d, got %d", size, pakHdrSize)
+		return nil
+	}
+	if pakHdrSize%4 != 0 {
+		report(KindHeaderAlign, -1, pakHdrSize&^3, pakHdrSize, "PAK header size %d not a multiple of 4", pakHdrSize)
+	}
+	// read archive offsets.
+	archiveOffsets := make([]uint32, pakHdrSize/4)
+	if err := binary.Read(io.NewSectionReader(r, 0, pakHdrSize), binary.LittleEndian, &archiveOffsets); err != nil {
+		return errors.WithStack(err)
+	}
+	// check bounds of files.
+	var entries []entry
+	for i := 0; i < len(archiveOffsets)-1; i++ {
+		start := int64(archiveOffsets[i])
+		end := int64(archiveOffsets[i+1])
+		switch {
+		case end < start:
+			report(KindNonMonotonic, i, start, end, "end offset %d of file less than start offset %d", end, start)
+			continue
+		case end > size:
+			report(KindOutOfBounds, i, start, end, "file extends %d bytes past end of archive at offset %d", end-size, size)
+			if start >= size {
+				continue
+			}
+			end = size
+		case end == start:
+			report(KindEmpty, i, start, end, "zero-length file")
+			continue
+		}
+		entries = append(entries, entry{index: i, start: start, end: end})
+	}
+	// check coverage of archive; files should be contiguous from the end of the
+	// PAK header to the end of the archive.
+	sorted := append([]entry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].start < sorted[j].start
+	})
+	pos := pakHdrSize
+	prev := -1
+	for _, e := range sorted {
+		switch {
+		case e.start > pos:
+			report(KindGap, -1, pos, e.start, "%d bytes not contained within any file", e.start-pos)
+		case e.start < pos:
+			owner := "PAK header"
+			if prev != -1 {
+				owner = fmt.Sprintf("file %d", prev)
+			}
+			report(KindOverlap, e.index, e.start, min64(pos, e.end), "file overlaps %s by %d bytes", owner, min64(pos, e.end)-e.start)
+		}
+		if e.end > pos {
+			pos = e.end
+			prev = e.index
+		}
+	}
+	if pos < size {
+		report(KindGap, -1, pos, size, "%d trailing bytes not contained within any file", size-pos)
+	}
+	// check subarchives.
+	for _, e := range entries {
+		sr := io.NewSectionReader(r, e.start, e.end-e.start)
+		ok, err := looksLikeSubarchive(sr, sr.Size())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !ok {
+			// not a subarchive.
+			continue
+		}
+		if c.Descend != nil && !c.Descend(sr, sr.Size()) {
+			continue
+		}
+		subArchive := append(append([]int(nil), archive...), e.index)
+		if err := c.check(diags, sr, sr.Size(), subArchive, base+e.start); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// looksLikeSubarchive reports whether the given file of the specified size
+// looks like a PAK subarchive. The check is looser than readPAKHeader, so that
+// damaged subarchives are checked as well; the PAK header size must be a
+// multiple of 4 within the bounds of the file, and more than half of the files
+// must have monotonic offsets within the bounds of the file.
+func looksLikeSubarchive(r io.ReaderAt, size int64) (bool, error) {
+	if size < 4 {
+		return false, nil
+	}
+	var rawHdrSize [4]byte
+	if _, err := r.ReadAt(rawHdrSize[:], 0); err != nil {
+		return false, errors.WithStack(err)
+	}
+	pakHdrSize := int64(binary.LittleEndian.Uint32(rawHdrSize[:]))
+	if pakHdrSize < 8 || pakHdrSize > size || pakHdrSize%4 != 0 {
+		return false, nil
+	}
+	archiveOffsets := make([]uint32, pakHdrSize/4)
+	if err := binary.Read(io.NewSectionReader(r, 0, pakHdrSize), binary.LittleEndian, &archiveOffsets); err != nil {
+		return false, errors.WithStack(err)
+	}
+	nfiles := len(archiveOffsets) - 1
+	valid := 0
+	for i := 0; i < nfiles; i++ {
+		start := int64(archiveOffsets[i])
+		end := int64(archiveOffsets[i+1])
+		if start <= end && end <= size {
+			valid++
+		}
+	}
+	return 2*valid > nfiles, nil
+}
+
+// min64 returns the smaller of x and y.
+func min64(x, y int64) int64 {
+	if x < y {
+		return x
+	}
+	return y
+}
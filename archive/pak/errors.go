@@ -0,0 +1,61 @@
+package pak
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Underlying errors of HeaderError, FileError and Diagnostic, which may be
+// tested using errors.Is.
+var (
+	// ErrTruncated reports a PAK archive too short to hold its header.
+	ErrTruncated = errors.New("truncated PAK archive")
+	// ErrBadHeaderSize reports a PAK header size less than 8 bytes or larger
+	// than the archive; and, as reported by Check, not a multiple of 4 bytes.
+	ErrBadHeaderSize = errors.New("invalid PAK header size")
+	// ErrBadOffset reports a file offset which is non-monotonic, overlapping or
+	// out of bounds, or an end offset not matching the size of the archive.
+	ErrBadOffset = errors.New("invalid PAK file offset")
+)
+
+// HeaderError reports an invalid PAK header.
+type HeaderError struct {
+	// Offset in bytes of the invalid header field.
+	Offset int64
+	// Description of the problem.
+	Reason string
+	// Underlying error (e.g. ErrTruncated); may be nil.
+	Err error
+}
+
+// Error returns the error message.
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("invalid PAK header at offset %d: %s", e.Offset, e.Reason)
+}
+
+// Unwrap returns the underlying error.
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+// FileError reports an invalid file of a PAK archive.
+type FileError struct {
+	// Index of the file within the archive.
+	Index int
+	// Start offset in bytes of the file within the archive.
+	Offset int64
+	// Description of the problem.
+	Reason string
+	// Underlying error (e.g. ErrBadOffset); may be nil.
+	Err error
+}
+
+// Error returns the error message.
+func (e *FileError) Error() string {
+	return fmt.Sprintf("invalid file %d at offset %d: %s", e.Index, e.Offset, e.Reason)
+}
+
+// Unwrap returns the underlying error.
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,152 @@
+// Package export streams the files of PAK archive trees into a single zip or
+// tar archive.
+//
+// Files are written in lexical order of their paths, with fixed timestamps and
+// permissions, so that exporting the same archive tree twice produces identical
+// output. No files are written to the file system.
+package export
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Format is an export format.
+type Format string
+
+// Export formats.
+const (
+	// Zip archive (deflate compressed).
+	Zip Format = "zip"
+	// Tar archive (uncompressed).
+	Tar Format = "tar"
+)
+
+// ModTime is the modification time of exported files.
+var ModTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Writer streams files of PAK archive trees into a zip or tar archive.
+type Writer struct {
+	// Export format.
+	format Format
+	// Underlying zip or tar writer; only one is non-nil.
+	zw *zip.Writer
+	tw *tar.Writer
+}
+
+// NewWriter returns a new writer of the given export format, writing to w.
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	ew := &Writer{format: format}
+	switch format {
+	case Zip:
+		ew.zw = zip.NewWriter(w)
+	case Tar:
+		ew.tw = tar.NewWriter(w)
+	default:
+		return nil, errors.Errorf("unsupported export format %q; expected %q or %q", format, Zip, Tar)
+	}
+	return ew, nil
+}
+
+// AddFS adds the files of the given archive tree (e.g. a pakfs file system),
+// walking the tree in lexical order. Directories are implied by the paths of
+// their files, and are not written themselves.
+//
+// The filter function, if non-nil, reports whether to add the given file or to
+// descend into the given directory.
+func (ew *Writer) AddFS(fsys fs.FS, filter func(name string, d fs.DirEntry) bool) error {
+	walk := func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if filter != nil && name != "." && !filter(name, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return ew.addFile(fsys, name, d)
+	}
+	if err := fs.WalkDir(fsys, ".", walk); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// addFile adds the given file of the archive tree.
+func (ew *Writer) addFile(fsys fs.FS, name string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	var w io.Writer
+	switch ew.format {
+	case Zip:
+		hdr := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: ModTime,
+		}
+		hdr.SetMode(0o644)
+		if w, err = ew.zw.CreateHeader(hdr); err != nil {
+			return errors.WithStack(err)
+		}
+	case Tar:
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     info.Size(),
+			Mode:     0o644,
+			ModTime:  ModTime,
+			Format:   tar.FormatPAX,
+		}
+		if err := ew.tw.WriteHeader(hdr); err != nil {
+			return errors.WithStack(err)
+		}
+		w = ew.tw
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return errors.Wrapf(err, "unable to export %q", name)
+	}
+	return nil
+}
+
+// Close finishes writing the zip or tar archive. It does not close the
+// underlying writer.
+func (ew *Writer) Close() error {
+	switch ew.format {
+	case Zip:
+		return errors.WithStack(ew.zw.Close())
+	default:
+		return errors.WithStack(ew.tw.Close())
+	}
+}
+
+// Write writes the files of the given archive tree to w, as a zip or tar
+// archive of the given export format.
+func Write(w io.Writer, format Format, fsys fs.FS) error {
+	ew, err := NewWriter(w, format)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ew.AddFS(fsys, nil); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ew.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Package listfile provides access to listfiles, which map the default paths of
+// files contained within PAK archive trees to descriptive file paths.
+//
+// Default paths are the paths assigned by pak_dump to unnamed files, relative
+// to the output directory (e.g. "X/archive_0001.pak"). Files of a renamed
+// subarchive are located in the directory of the new subarchive name (e.g.
+// "X/cursors/archive_0000.pak" for files of "X/cursors.pak").
+//
+// Example listfile:
+//
+//	{
+//		"X/archive_0000.pak": "X/core.pak",
+//		"X/core/file_0000.bin": "X/core/font8.bin",
+//		"X/core/file_0002.bin": "X/core/core.pal"
+//	}
+package listfile
+
+import (
+	"sort"
+
+	"github.com/mewkiz/pkg/jsonutil"
+	"github.com/pkg/errors"
+)
+
+// Listfile maps default paths to file paths.
+type Listfile struct {
+	// File paths by default path.
+	names map[string]string
+	// Default paths by file path, sorted.
+	rawPaths map[string][]string
+}
+
+// New returns a listfile of the given mapping from default paths to file
+// paths.
+func New(names map[string]string) *Listfile {
+	l := &Listfile{
+		names:    make(map[string]string, len(names)),
+		rawPaths: make(map[string][]string),
+	}
+	for rawPath, name := range names {
+		l.names[rawPath] = name
+		l.rawPaths[name] = append(l.rawPaths[name], rawPath)
+	}
+	for _, rawPaths := range l.rawPaths {
+		sort.Strings(rawPaths)
+	}
+	return l
+}
+
+// ParseFile parses the given listfile (JSON format). An empty listfile is
+// returned if listfilePath is empty.
+func ParseFile(listfilePath string) (*Listfile, error) {
+	names := make(map[string]string)
+	if len(listfilePath) == 0 {
+		return New(names), nil
+	}
+	if err := jsonutil.ParseFile(listfilePath, &names); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return New(names), nil
+}
+
+// Len returns the number of entries of the listfile.
+func (l *Listfile) Len() int {
+	return len(l.names)
+}
+
+// Keys returns the default paths of the listfile, sorted.
+func (l *Listfile) Keys() []string {
+	keys := make([]string, 0, len(l.names))
+	for rawPath := range l.names {
+		keys = append(keys, rawPath)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Name returns the file path of the given default path, and a boolean
+// indicating whether the default path is present in the listfile.
+func (l *Listfile) Name(rawPath string) (string, bool) {
+	name, ok := l.names[rawPath]
+	return name, ok
+}
+
+// Resolve returns the file path of the given default path if present in the
+// listfile, and the default path itself otherwise.
+func (l *Listfile) Resolve(rawPath string) string {
+	if name, ok := l.names[rawPath]; ok {
+		return name
+	}
+	return rawPath
+}
+
+// RawPath returns the default path of the given file path, and a boolean
+// indicating whether the file path is present in the listfile. The first
+// default path (in sorted order) is returned if several default paths map to
+// the same file path.
+func (l *Listfile) RawPath(name string) (string, bool) {
+	rawPaths := l.rawPaths[name]
+	if len(rawPaths) == 0 {
+		return "", false
+	}
+	return rawPaths[0], true
+}
+
+// RawPaths returns the default paths which map to the given file path, sorted.
+func (l *Listfile) RawPaths(name string) []string {
+	return append([]string(nil), l.rawPaths[name]...)
+}
+
+// Report is a listfile coverage report of a PAK archive tree.
+type Report struct {
+	// Default paths of files not named by the listfile.
+	Unnamed []string
+	// Default paths of files by file path, for file paths that several files
+	// resolve to (e.g. when "X/sounds/sound_0044.wav" is renamed to
+	// "X/sounds/sound_0015.wav" while "X/sounds/sound_0015.wav" is unnamed).
+	Duplicates map[string][]string
+	// Default paths of the listfile which are not present in the archive tree.
+	Dangling []string
+}
+
+// Check checks the listfile against the given default paths of the files
+// contained within a PAK archive tree, and reports unnamed files, duplicate file
+// paths and dangling listfile entries.
+func (l *Listfile) Check(rawPaths []string) *Report {
+	report := &Report{
+		Duplicates: make(map[string][]string),
+	}
+	present := make(map[string]bool, len(rawPaths))
+	resolved := make(map[string][]string)
+	for _, rawPath := range rawPaths {
+		present[rawPath] = true
+		name, ok := l.names[rawPath]
+		if !ok {
+			report.Unnamed = append(report.Unnamed, rawPath)
+			name = rawPath
+		}
+		resolved[name] = append(resolved[name], rawPath)
+	}
+	for name, rawPaths := range resolved {
+		if len(rawPaths) > 1 {
+			sort.Strings(rawPaths)
+			report.Duplicates[name] = rawPaths
+		}
+	}
+	for rawPath := range l.names {
+		if !present[rawPath] {
+			report.Dangling = append(report.Dangling, rawPath)
+		}
+	}
+	sort.Strings(report.Unnamed)
+	sort.Strings(report.Dangling)
+	return report
+}
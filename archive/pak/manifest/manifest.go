@@ -0,0 +1,115 @@
+// Package manifest provides access to dump manifests, which record the source
+// of each file extracted from a PAK archive tree.
+//
+// Example manifest:
+//
+//	{
+//		"files": [
+//			{
+//				"path": "X/core.pak",
+//				"raw_path": "X/archive_0000.pak",
+//				"archive": "X.PAK",
+//				"index": 0,
+//				"offset": 60,
+//				"abs_offset": 60,
+//				"size": 1306,
+//				"sha1": "…",
+//				"extracted": false,
+//				"subarchive": true
+//			},
+//			{
+//				"path": "X/core/core.pal",
+//				"raw_path": "X/core/file_0002.bin",
+//				"archive": "X/core.pak",
+//				"index": 2,
+//				…
+//			}
+//		]
+//	}
+package manifest
+
+import (
+	"sort"
+
+	"github.com/mewkiz/pkg/jsonutil"
+	"github.com/pkg/errors"
+)
+
+// Manifest records the source of each file of a dumped PAK archive tree.
+type Manifest struct {
+	// Files of the (sub)archives, sorted by archive path and file index.
+	Files []*File `json:"files"`
+}
+
+// File records the source of a file (or subarchive) contained within a PAK
+// archive tree. All paths are slash-separated and relative to the output
+// directory of the dump.
+type File struct {
+	// File path (e.g. "X/core/core.pal").
+	Path string `json:"path"`
+	// Default path, as used for listfile lookup (e.g. "X/core/file_0002.bin").
+	RawPath string `json:"raw_path"`
+	// Path of the source archive containing the file (e.g. "X/core.pak"); the
+	// file name of the top-level archive (e.g. "X.PAK") for its files.
+	Archive string `json:"archive"`
+	// Index of the file within its source archive.
+	Index int `json:"index"`
+	// Offset in bytes of the file within its source archive.
+	Offset int64 `json:"offset"`
+	// Offset in bytes of the file within the top-level archive.
+	AbsOffset int64 `json:"abs_offset"`
+	// Size in bytes of the file.
+	Size int64 `json:"size"`
+	// SHA1 hash of the file contents (hex encoded).
+	SHA1 string `json:"sha1"`
+	// Reports whether the file was written to Path.
+	Extracted bool `json:"extracted"`
+	// Reports whether the files of the subarchive were extracted to the
+	// directory of Path without extension.
+	Subarchive bool `json:"subarchive"`
+}
+
+// ParseFile parses the given manifest (JSON format).
+func ParseFile(manifestPath string) (*Manifest, error) {
+	m := &Manifest{}
+	if err := jsonutil.ParseFile(manifestPath, m); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return m, nil
+}
+
+// WriteFile writes the manifest to the given path (JSON format), sorting its
+// files by archive path and file index.
+func (m *Manifest) WriteFile(manifestPath string) error {
+	m.Sort()
+	if err := jsonutil.WriteFile(manifestPath, m); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Sort sorts the files of the manifest by archive path and file index.
+func (m *Manifest) Sort() {
+	sort.SliceStable(m.Files, func(i, j int) bool {
+		fi, fj := m.Files[i], m.Files[j]
+		if fi.Archive != fj.Archive {
+			return fi.Archive < fj.Archive
+		}
+		return fi.Index < fj.Index
+	})
+}
+
+// Archives returns the files of the manifest grouped by source archive path,
+// with the files of each archive sorted by file index.
+func (m *Manifest) Archives() map[string][]*File {
+	archives := make(map[string][]*File)
+	for _, f := range m.Files {
+		archives[f.Archive] = append(archives[f.Archive], f)
+	}
+	for _, files := range archives {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Index < files[j].Index
+		})
+	}
+	return archives
+}
@@ -4,6 +4,7 @@ package pak
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"io/ioutil"
 
@@ -18,6 +19,13 @@ func Extract(pakPath string) ([][]byte, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	return Split(buf)
+}
+
+// Split splits the given PAK archive contents, returning the contents of the
+// top-level files (and subarchives) contained within the archive. The returned
+// file contents share memory with buf.
+func Split(buf []byte) ([][]byte, error) {
 	// parse PAK header.
 	archiveOffsets, err := ParsePAKHeader(buf)
 	if err != nil {
@@ -29,6 +37,9 @@ func Extract(pakPath string) ([][]byte, error) {
 	for i := 0; i < narchives; i++ {
 		startOffset := archiveOffsets[i]
 		endOffset := archiveOffsets[i+1]
+		if startOffset > endOffset {
+			return nil, errors.WithStack(invalidEndOffset(i, startOffset, endOffset))
+		}
 		fileContents := buf[startOffset:endOffset:endOffset]
 		filesContents = append(filesContents, fileContents)
 	}
@@ -38,26 +49,61 @@ func Extract(pakPath string) ([][]byte, error) {
 // ParsePAKHeader parses the PAK header of the given PAK file contents, and
 // returns the archive offsets.
 func ParsePAKHeader(buf []byte) ([]uint32, error) {
-	if len(buf) < 4 {
-		return nil, errors.Errorf("too short PAK header; expected >= 4, got %d", len(buf))
+	return readPAKHeader(bytes.NewReader(buf), int64(len(buf)))
+}
+
+// readPAKHeader reads the PAK header of the given PAK archive of the specified
+// size, and returns the archive offsets.
+func readPAKHeader(r io.ReaderAt, size int64) ([]uint32, error) {
+	if size < 4 {
+		err := &HeaderError{
+			Offset: 0,
+			Reason: fmt.Sprintf("too short; expected >= 4 bytes, got %d", size),
+			Err:    ErrTruncated,
+		}
+		return nil, errors.WithStack(err)
+	}
+	var rawHdrSize [4]byte
+	if _, err := r.ReadAt(rawHdrSize[:], 0); err != nil {
+		return nil, errors.WithStack(err)
 	}
-	pakHdrSize := int(binary.LittleEndian.Uint32(buf[0:4]))
+	pakHdrSize := int64(binary.LittleEndian.Uint32(rawHdrSize[:]))
 	// the minimum valid PAK archive header is 8 bytes as a start and end offset
 	// is required for each file. a PAK archive containing a single empty file
 	// would have the PAK header `00 00 00 00  08 00 00 00`.
-	if pakHdrSize < 8 || pakHdrSize > len(buf) {
-		return nil, errors.Errorf("invalid PAK header size; expected >= 8 and <= len(buf)=%d, got %d", len(buf), pakHdrSize)
+	if pakHdrSize < 8 || pakHdrSize > size {
+		err := &HeaderError{
+			Offset: 0,
+			Reason: fmt.Sprintf("invalid header size; expected >= 8 and <= %d, got %d", size, pakHdrSize),
+			Err:    ErrBadHeaderSize,
+		}
+		return nil, errors.WithStack(err)
 	}
-	r := bytes.NewReader(buf)
-	pakHdrReader := io.NewSectionReader(r, 0, int64(pakHdrSize))
+	pakHdrReader := io.NewSectionReader(r, 0, pakHdrSize)
 	archiveOffsetsLen := pakHdrSize / 4
 	archiveOffsets := make([]uint32, archiveOffsetsLen)
 	if err := binary.Read(pakHdrReader, binary.LittleEndian, &archiveOffsets); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	narchives := len(archiveOffsets) - 1
-	if len(buf) != int(archiveOffsets[narchives]) {
-		return nil, errors.Errorf("mismatch between archiveOffsets[%d]=%d and len(buf)=%d", narchives, archiveOffsets[narchives], len(buf))
+	if size != int64(archiveOffsets[narchives]) {
+		err := &HeaderError{
+			Offset: int64(narchives) * 4,
+			Reason: fmt.Sprintf("mismatch between archiveOffsets[%d]=%d and archive size %d", narchives, archiveOffsets[narchives], size),
+			Err:    ErrBadOffset,
+		}
+		return nil, errors.WithStack(err)
 	}
 	return archiveOffsets, nil
 }
+
+// invalidEndOffset returns a FileError for the i:th file, whose end offset is
+// less than its start offset.
+func invalidEndOffset(i int, startOffset, endOffset uint32) *FileError {
+	return &FileError{
+		Index:  i,
+		Offset: int64(startOffset),
+		Reason: fmt.Sprintf("invalid end offset; expected >= %d, got %d", startOffset, endOffset),
+		Err:    ErrBadOffset,
+	}
+}
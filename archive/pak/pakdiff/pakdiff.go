@@ -0,0 +1,372 @@
+// Package pakdiff reports the differences between two PAK archive trees, such
+// as two builds of X.PAK.
+//
+// Files are matched by path, so archive trees are typically compared through
+// pakfs file systems named by the same listfile. For changed ZEL images, the
+// indices of differing frames are reported, and for changed MAP files, the
+// names of differing layers.
+package pakdiff
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mewspring/pak/archive/pak/pakfs"
+	"github.com/mewspring/pak/image/zel"
+	"github.com/mewspring/pak/level/maps"
+	"github.com/pkg/errors"
+)
+
+// Kind is the kind of a change.
+type Kind string
+
+// Change kinds.
+const (
+	// File present only in the new archive tree.
+	Added Kind = "added"
+	// File present only in the old archive tree.
+	Removed Kind = "removed"
+	// File of different size.
+	Resized Kind = "resized"
+	// File of same size with different contents.
+	Changed Kind = "changed"
+	// File of different path with same contents (e.g. unnamed file whose
+	// detected content type differs between builds).
+	Renamed Kind = "renamed"
+)
+
+// Report is the difference between two PAK archive trees.
+type Report struct {
+	// Changed files, sorted by path.
+	Changes []*Change `json:"changes"`
+}
+
+// Change is a difference of a file between two PAK archive trees.
+type Change struct {
+	// Kind of change.
+	Kind Kind `json:"kind"`
+	// File path (e.g. "X/cursors/hand.zel"); the path in the new archive tree
+	// if the path differs.
+	Path string `json:"path"`
+	// File path in the old archive tree; empty if same as Path.
+	OldPath string `json:"old_path,omitempty"`
+	// Default path of the file in the old and new archive tree respectively
+	// (e.g. "X/cursors/archive_0000.pak"); empty if not present or not known.
+	OldRawPath string `json:"old_raw_path,omitempty"`
+	NewRawPath string `json:"new_raw_path,omitempty"`
+	// Size in bytes of the file in the old and new archive tree respectively.
+	OldSize int64 `json:"old_size"`
+	NewSize int64 `json:"new_size"`
+	// Indices of differing frames of ZEL images, including frames present in
+	// only one of the images.
+	Frames []int `json:"frames,omitempty"`
+	// Names of differing layers of MAP files (see Layers).
+	Layers []string `json:"layers,omitempty"`
+}
+
+// Diff returns the differences between the files of the given old and new
+// archive trees. Directories are not compared themselves, only the files they
+// contain. The archive trees are walked in parallel, and files are compared
+// concurrently.
+//
+// Files present in only one of the archive trees but with the same default path
+// (e.g. unnamed files whose detected content type differs between builds) are
+// reported as a single change of the new path.
+//
+// Frame and layer differences are reported on a best-effort basis; they are
+// omitted if either version of the file fails to parse.
+func Diff(oldFS, newFS fs.FS) (*Report, error) {
+	// walk old and new archive tree in parallel.
+	var (
+		oldFiles, newFiles map[string]file
+		oldErr, newErr     error
+		wg                 sync.WaitGroup
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		oldFiles, oldErr = walkFiles(oldFS)
+	}()
+	go func() {
+		defer wg.Done()
+		newFiles, newErr = walkFiles(newFS)
+	}()
+	wg.Wait()
+	if oldErr != nil {
+		return nil, errors.WithStack(oldErr)
+	}
+	if newErr != nil {
+		return nil, errors.WithStack(newErr)
+	}
+	// merge file paths of old and new archive tree.
+	var filePaths []string
+	for filePath := range oldFiles {
+		filePaths = append(filePaths, filePath)
+	}
+	for filePath := range newFiles {
+		if _, ok := oldFiles[filePath]; !ok {
+			filePaths = append(filePaths, filePath)
+		}
+	}
+	sort.Strings(filePaths)
+	// locate removed files by default path, to pair them with added files of
+	// the same default path.
+	removed := make(map[string]string)
+	for _, filePath := range filePaths {
+		oldFile, inOld := oldFiles[filePath]
+		if _, inNew := newFiles[filePath]; inOld && !inNew && len(oldFile.rawPath) > 0 {
+			removed[oldFile.rawPath] = filePath
+		}
+	}
+	report := &Report{}
+	var pairs []pair
+	paired := make(map[string]bool)
+	for _, filePath := range filePaths {
+		oldFile, inOld := oldFiles[filePath]
+		newFile, inNew := newFiles[filePath]
+		switch {
+		case !inOld:
+			if oldPath, ok := removed[newFile.rawPath]; ok && len(newFile.rawPath) > 0 {
+				// same file with different path.
+				pairs = append(pairs, pair{oldPath: oldPath, newPath: filePath, oldFile: oldFiles[oldPath], newFile: newFile})
+				paired[oldPath] = true
+				continue
+			}
+			change := &Change{
+				Kind:       Added,
+				Path:       filePath,
+				NewRawPath: newFile.rawPath,
+				NewSize:    newFile.size,
+			}
+			report.Changes = append(report.Changes, change)
+		case !inNew:
+			// removed files are added after pairing.
+		default:
+			pairs = append(pairs, pair{oldPath: filePath, newPath: filePath, oldFile: oldFile, newFile: newFile})
+		}
+	}
+	for _, filePath := range filePaths {
+		oldFile, inOld := oldFiles[filePath]
+		if _, inNew := newFiles[filePath]; !inOld || inNew || paired[filePath] {
+			continue
+		}
+		change := &Change{
+			Kind:       Removed,
+			Path:       filePath,
+			OldRawPath: oldFile.rawPath,
+			OldSize:    oldFile.size,
+		}
+		report.Changes = append(report.Changes, change)
+	}
+	// compare files present in both archive trees concurrently.
+	changes, err := comparePairs(oldFS, newFS, pairs)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	report.Changes = append(report.Changes, changes...)
+	sort.Slice(report.Changes, func(i, j int) bool {
+		return report.Changes[i].Path < report.Changes[j].Path
+	})
+	return report, nil
+}
+
+// pair is a file present in both the old and the new archive tree.
+type pair struct {
+	// File path in the old and new archive tree respectively.
+	oldPath, newPath string
+	// File in the old and new archive tree respectively.
+	oldFile, newFile file
+}
+
+// comparePairs compares the given files of the old and new archive trees
+// concurrently, returning the changes of differing files.
+func comparePairs(oldFS, newFS fs.FS, pairs []pair) ([]*Change, error) {
+	changes := make([]*Change, len(pairs))
+	errs := make([]error, len(pairs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < runtime.NumCPU(); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				changes[i], errs[i] = comparePair(oldFS, newFS, pairs[i])
+			}
+		}()
+	}
+	for i := range pairs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	var diffs []*Change
+	for i, change := range changes {
+		if errs[i] != nil {
+			return nil, errors.WithStack(errs[i])
+		}
+		if change != nil {
+			diffs = append(diffs, change)
+		}
+	}
+	return diffs, nil
+}
+
+// comparePair compares the given file of the old and new archive trees,
+// returning its change, or nil if identical.
+func comparePair(oldFS, newFS fs.FS, p pair) (*Change, error) {
+	change := &Change{
+		Path:       p.newPath,
+		OldRawPath: p.oldFile.rawPath,
+		NewRawPath: p.newFile.rawPath,
+		OldSize:    p.oldFile.size,
+		NewSize:    p.newFile.size,
+	}
+	if p.oldPath != p.newPath {
+		change.OldPath = p.oldPath
+	}
+	oldBuf, err := fs.ReadFile(oldFS, p.oldPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	newBuf, err := fs.ReadFile(newFS, p.newPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	switch {
+	case len(oldBuf) != len(newBuf):
+		change.Kind = Resized
+	case !bytes.Equal(oldBuf, newBuf):
+		change.Kind = Changed
+	case p.oldPath != p.newPath:
+		change.Kind = Renamed
+		return change, nil
+	default:
+		// identical file.
+		return nil, nil
+	}
+	oldExt := strings.ToLower(path.Ext(p.oldPath))
+	switch strings.ToLower(path.Ext(p.newPath)) {
+	case ".zel":
+		if oldExt == ".zel" {
+			change.Frames = diffFrames(oldBuf, newBuf)
+		}
+	case ".map":
+		if oldExt == ".map" {
+			change.Layers = diffLayers(oldBuf, newBuf)
+		}
+	}
+	return change, nil
+}
+
+// file is a file of an archive tree.
+type file struct {
+	// Default path of the file; empty if not known.
+	rawPath string
+	// Size in bytes of the file.
+	size int64
+}
+
+// walkFiles returns the files of the given archive tree, by file path.
+func walkFiles(fsys fs.FS) (map[string]file, error) {
+	files := make(map[string]file)
+	walk := func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		f := file{size: info.Size()}
+		if e, ok := info.Sys().(*pakfs.Entry); ok {
+			f.rawPath = e.RawPath
+		}
+		files[filePath] = f
+		return nil
+	}
+	if err := fs.WalkDir(fsys, ".", walk); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return files, nil
+}
+
+// diffFrames returns the indices of differing frames of the given ZEL images,
+// or nil if either image fails to parse.
+func diffFrames(oldBuf, newBuf []byte) []int {
+	oldFrames, err := zel.SplitFrames(oldBuf)
+	if err != nil {
+		return nil
+	}
+	newFrames, err := zel.SplitFrames(newBuf)
+	if err != nil {
+		return nil
+	}
+	nframes := len(oldFrames)
+	if len(newFrames) > nframes {
+		nframes = len(newFrames)
+	}
+	var frames []int
+	for i := 0; i < nframes; i++ {
+		if i >= len(oldFrames) || i >= len(newFrames) || !bytes.Equal(oldFrames[i], newFrames[i]) {
+			frames = append(frames, i)
+		}
+	}
+	return frames
+}
+
+// Layers holds the names of MAP file layers, in order of storage.
+//
+//	header        render with light flag and walls tileset ID
+//	solid         collisions
+//	floors        floor tile frame indices
+//	backgrounds   backgrounds overlays
+//	shadows       shadows overlays
+//	buildings     building tiles
+//	objects       object tiles
+//	base_walls    base walls tiles
+var Layers = []string{"header", "solid", "floors", "backgrounds", "shadows", "buildings", "objects", "base_walls"}
+
+// mapLayers returns the layers of the given MAP file, in the order of Layers.
+func mapLayers(m *maps.Map) []interface{} {
+	header := struct {
+		Unused0004         uint32
+		RenderWithLight    uint8
+		BaseWallsTilesetID uint32
+	}{
+		Unused0004:         m.Unused0004,
+		RenderWithLight:    m.RenderWithLight,
+		BaseWallsTilesetID: m.BaseWallsTilesetID,
+	}
+	return []interface{}{header, m.SolidMap, m.FloorFrameMap, m.Backgrounds, m.Shadows, m.Buildings, m.Objects, m.BaseWalls}
+}
+
+// diffLayers returns the names of differing layers of the given MAP files, or
+// nil if either file fails to parse.
+func diffLayers(oldBuf, newBuf []byte) []string {
+	oldMap, err := maps.Parse(oldBuf)
+	if err != nil {
+		return nil
+	}
+	newMap, err := maps.Parse(newBuf)
+	if err != nil {
+		return nil
+	}
+	oldLayers := mapLayers(oldMap)
+	newLayers := mapLayers(newMap)
+	var layers []string
+	for i, name := range Layers {
+		if !reflect.DeepEqual(oldLayers[i], newLayers[i]) {
+			layers = append(layers, name)
+		}
+	}
+	return layers
+}
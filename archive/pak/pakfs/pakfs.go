@@ -0,0 +1,520 @@
+// Package pakfs provides a file system view of PAK archive trees.
+//
+// Nested subarchives are exposed as directories, and files are named as they
+// would be by pak_dump; using the names of a listfile if present, and falling
+// back to default names (e.g. "X/archive_0012.pak", "X/core/file_0002.bin" and
+// "X/sounds/sound_0044.wav") with the file extension of the detected content
+// type (e.g. "X/archive_0003.zel") otherwise. Empty files are omitted.
+//
+// When several files of an archive share the same name, the last file takes the
+// name, as when dumped by pak_dump, and earlier files are named by their index
+// within the archive (e.g. "X/towner_9_dir~12.zel"); see ShadowedPath. The
+// files of a shadowed subarchive are not named by the listfile, as their
+// default paths are located within the directory of the shadowed subarchive
+// (e.g. "X/foo~3/file_0000.bin").
+package pakfs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/mewspring/pak/archive/pak"
+	"github.com/mewspring/pak/archive/pak/listfile"
+	"github.com/mewspring/pak/archive/pak/sniff"
+	"github.com/pkg/errors"
+)
+
+// FS is a read-only file system view of a PAK archive tree. It is safe for
+// concurrent use.
+type FS struct {
+	// Root directory, containing the top-level archive directory.
+	root *dir
+	// Listfile mapping from default paths to file paths.
+	lf *listfile.Listfile
+	// Underlying PAK archive file; nil if not opened by pakfs.
+	closer io.Closer
+}
+
+// Open opens the given PAK archive as a file system, naming files using the
+// given listfile (may be nil). The file system must be closed after use.
+func Open(pakPath string, lf *listfile.Listfile) (*FS, error) {
+	rc, err := pak.OpenReader(pakPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	fsys := New(rc.Reader, filepath.Base(pakPath), lf)
+	fsys.closer = rc
+	return fsys, nil
+}
+
+// New returns a file system view of the given PAK archive, naming files using
+// the given listfile (may be nil). The contents of the archive are exposed in
+// the top-level directory named after the archive file name without extension
+// (e.g. "X" for "X.PAK").
+func New(r *pak.Reader, pakName string, lf *listfile.Listfile) *FS {
+	if lf == nil {
+		lf = listfile.New(nil)
+	}
+	fsys := &FS{lf: lf}
+	top := &Entry{
+		Path:    pakName,
+		RawPath: pakName,
+		Dir:     pathutil.TrimExt(pakName),
+		Index:   -1,
+		Size:    r.Size(),
+	}
+	top.sub = fsys.newDir(top.Dir, func() (*pak.Reader, error) {
+		return r, nil
+	})
+	fsys.root = &dir{
+		path:    ".",
+		entries: []*Entry{top},
+		byName:  map[string]*Entry{top.Dir: top},
+	}
+	// the root directory is not backed by an archive.
+	fsys.root.once.Do(func() {})
+	return fsys
+}
+
+// Close closes the underlying PAK archive file, if opened by Open.
+func (fsys *FS) Close() error {
+	if fsys.closer == nil {
+		return nil
+	}
+	return fsys.closer.Close()
+}
+
+// Entry is a file or subarchive of a PAK archive tree. Entry is returned by the
+// Sys method of the fs.FileInfo of files and directories.
+type Entry struct {
+	// File path of the entry (e.g. "X/cursors.pak" or "X/cursors/hand.zel").
+	Path string
+	// Default path of the entry as used for listfile lookup (e.g.
+	// "X/archive_0001.pak" or "X/cursors/archive_0000.pak").
+	RawPath string
+	// Directory path of the subarchive; empty if the entry is not exposed as a
+	// directory (e.g. "X/cursors").
+	Dir string
+	// Index of the entry within its parent archive; -1 for the top-level
+	// archive.
+	Index int
+	// Offset in bytes of the entry within its parent archive.
+	Offset int64
+	// Size in bytes of the entry.
+	Size int64
+
+	// Reports whether the entry was named by the listfile.
+	named bool
+	// Parent archive; nil for the top-level archive.
+	parent *pak.Reader
+	// Subarchive directory; non-nil if the entry is exposed as a directory.
+	sub *dir
+}
+
+// IsDir reports whether the entry is a subarchive exposed as a directory.
+func (e *Entry) IsDir() bool {
+	return e.sub != nil
+}
+
+// Named reports whether the entry was named by the listfile.
+func (e *Entry) Named() bool {
+	return e.named
+}
+
+// Open returns a section reader of the contents of the entry.
+func (e *Entry) Open() (*io.SectionReader, error) {
+	if e.parent == nil {
+		r, err := e.sub.reader()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return r.Contents(), nil
+	}
+	return e.parent.File(e.Index), nil
+}
+
+// fsPath returns the path of the entry within the file system.
+func (e *Entry) fsPath() string {
+	if e.sub != nil {
+		return e.Dir
+	}
+	return e.Path
+}
+
+// name returns the base name of the entry within the file system.
+func (e *Entry) name() string {
+	return path.Base(e.fsPath())
+}
+
+// --- [ file system ] ---------------------------------------------------------
+
+// Open opens the named file or directory.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	e, d, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if d != nil {
+		return &dirFile{d: d, info: fileInfo{name: path.Base(name), e: e}}, nil
+	}
+	sr, err := e.Open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{SectionReader: sr, info: fileInfo{name: e.name(), e: e}}, nil
+}
+
+// Stat returns file information of the named file or directory.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	e, _, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{name: path.Base(name), e: e}, nil
+}
+
+// ReadDir reads the named directory, returning its entries sorted by file
+// name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	_, d, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries, err := d.list()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return dirEntries(entries), nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	e, d, err := fsys.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if d != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+	}
+	sr, err := e.Open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	buf := make([]byte, sr.Size())
+	if _, err := io.ReadFull(sr, buf); err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return buf, nil
+}
+
+// lookup locates the named file or directory. The returned directory is
+// non-nil if the name refers to a directory. The returned entry is nil for the
+// root directory.
+func (fsys *FS) lookup(op, name string) (*Entry, *dir, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil, fsys.root, nil
+	}
+	cur := fsys.root
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if _, err := cur.list(); err != nil {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		e, ok := cur.byName[part]
+		if !ok {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if i == len(parts)-1 {
+			return e, e.sub, nil
+		}
+		if e.sub == nil {
+			return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		cur = e.sub
+	}
+	panic("unreachable")
+}
+
+// --- [ directory ] -----------------------------------------------------------
+
+// dir is a directory of the file system, backed by a (sub)archive whose
+// entries are loaded on first use.
+type dir struct {
+	fsys *FS
+	// Directory path (e.g. "X/cursors").
+	path string
+	// Opens the backing archive.
+	open func() (*pak.Reader, error)
+
+	// Loads the directory entries once.
+	once sync.Once
+	// Backing archive.
+	r *pak.Reader
+	// Directory entries, sorted by name.
+	entries []*Entry
+	// Directory entries by name.
+	byName map[string]*Entry
+	// Error encountered while loading directory entries.
+	err error
+}
+
+// newDir returns a new directory of the given path, backed by the archive
+// returned by open.
+func (fsys *FS) newDir(dirPath string, open func() (*pak.Reader, error)) *dir {
+	return &dir{fsys: fsys, path: dirPath, open: open}
+}
+
+// reader returns the backing archive of the directory.
+func (d *dir) reader() (*pak.Reader, error) {
+	if _, err := d.list(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return d.r, nil
+}
+
+// list returns the entries of the directory, loading them on first use.
+func (d *dir) list() ([]*Entry, error) {
+	d.once.Do(func() {
+		d.err = d.load()
+	})
+	return d.entries, d.err
+}
+
+// load loads the entries of the directory from its backing archive.
+func (d *dir) load() error {
+	r, err := d.open()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	d.r = r
+	d.byName = make(map[string]*Entry)
+	for i := 0; i < r.Len(); i++ {
+		if r.FileSize(i) == 0 {
+			// skip empty file.
+			continue
+		}
+		rawPath := path.Join(d.path, RawName(r, i))
+		filePath, named := FilePath(r, i, d.path, d.fsys.lf)
+		e := &Entry{
+			Path:    filePath,
+			RawPath: rawPath,
+			Index:   i,
+			Offset:  r.Offset(i),
+			Size:    r.FileSize(i),
+			named:   named,
+			parent:  r,
+		}
+		if IsDir(r, i, filePath) {
+			i := i
+			e.Dir = pathutil.TrimExt(filePath)
+			e.sub = d.fsys.newDir(e.Dir, func() (*pak.Reader, error) {
+				return r.Subarchive(i)
+			})
+		}
+		d.entries = append(d.entries, e)
+	}
+	// later entries take the name of earlier entries of the same name, as when
+	// dumped by pak_dump; shadowed entries are kept reachable by index (e.g.
+	// "X/towner_9_dir~12.zel").
+	last := make(map[string]*Entry)
+	for _, e := range d.entries {
+		last[e.name()] = e
+	}
+	for _, e := range d.entries {
+		if last[e.name()] != e {
+			e.Path = ShadowedPath(e.Path, e.Index)
+			if e.sub != nil {
+				e.Dir = pathutil.TrimExt(e.Path)
+				e.sub.path = e.Dir
+			}
+		}
+		d.byName[e.name()] = e
+	}
+	sort.Slice(d.entries, func(i, j int) bool {
+		return d.entries[i].name() < d.entries[j].name()
+	})
+	return nil
+}
+
+// FilePath returns the file path of the i:th file of the given archive, whose
+// directory path is dirPath (e.g. "X/cursors"), and reports whether the file
+// was named by the given listfile (may be nil). Unnamed files use their default
+// path, with the file extension of the detected content type if known.
+func FilePath(r *pak.Reader, i int, dirPath string, lf *listfile.Listfile) (string, bool) {
+	rawPath := path.Join(dirPath, RawName(r, i))
+	if lf != nil {
+		if newPath, ok := lf.Name(rawPath); ok {
+			// only the base name is used, to keep the entry in its directory.
+			return path.Join(dirPath, path.Base(newPath)), true
+		}
+	}
+	if typ := sniff.Detect(r.File(i), r.FileSize(i)); typ != sniff.Unknown {
+		return pathutil.TrimExt(rawPath) + typ.Ext, false
+	}
+	return rawPath, false
+}
+
+// IsDir reports whether the i:th file of the given archive, of the given file
+// path, is a subarchive exposed as a directory; as named with the ".pak"
+// extension.
+func IsDir(r *pak.Reader, i int, filePath string) bool {
+	return path.Ext(filePath) == ".pak" && r.IsSubarchive(i)
+}
+
+// ShadowedPath returns the file path of the entry of the given index whose file
+// path is shadowed by a later entry of the same directory (e.g.
+// "X/towner_9_dir~12.zel" for "X/towner_9_dir.zel").
+func ShadowedPath(filePath string, index int) string {
+	ext := path.Ext(filePath)
+	return fmt.Sprintf("%s~%d%s", strings.TrimSuffix(filePath, ext), index, ext)
+}
+
+// RawName returns the default file name of the i:th file of the given archive
+// (e.g. "archive_0012.pak", "file_0002.bin" or "sound_0044.wav").
+func RawName(r *pak.Reader, i int) string {
+	name := "archive"
+	ext := "pak"
+	if !r.IsSubarchive(i) {
+		if isSound(r.File(i)) {
+			name = "sound"
+			ext = "wav"
+		} else {
+			name = "file"
+			ext = "bin"
+		}
+	}
+	return fmt.Sprintf("%s_%04d.%s", name, i, ext)
+}
+
+// isSound reports whether the given contents is a WAV sound file.
+func isSound(r io.ReaderAt) bool {
+	var magic [4]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return false
+	}
+	return string(magic[:]) == "RIFF"
+}
+
+// --- [ files ] ---------------------------------------------------------------
+
+// file is an open file of the file system.
+type file struct {
+	*io.SectionReader
+	info fileInfo
+}
+
+// Stat returns file information of the file.
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Close closes the file.
+func (f *file) Close() error {
+	return nil
+}
+
+// dirFile is an open directory of the file system.
+type dirFile struct {
+	d    *dir
+	info fileInfo
+	// Number of directory entries read.
+	off int
+}
+
+// Stat returns file information of the directory.
+func (f *dirFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Read returns an error; directories cannot be read.
+func (f *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: f.d.path, Err: errors.New("is a directory")}
+}
+
+// Close closes the directory.
+func (f *dirFile) Close() error {
+	return nil
+}
+
+// ReadDir reads the contents of the directory, as specified by fs.ReadDirFile.
+func (f *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.d.list()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: f.d.path, Err: err}
+	}
+	entries = entries[f.off:]
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if n < len(entries) {
+			entries = entries[:n]
+		}
+	}
+	f.off += len(entries)
+	return dirEntries(entries), nil
+}
+
+// dirEntries returns the directory entries of the given entries.
+func dirEntries(entries []*Entry) []fs.DirEntry {
+	dirEntries := make([]fs.DirEntry, len(entries))
+	for i, e := range entries {
+		dirEntries[i] = fs.FileInfoToDirEntry(fileInfo{name: e.name(), e: e})
+	}
+	return dirEntries
+}
+
+// fileInfo holds file information of a file or directory.
+type fileInfo struct {
+	name string
+	// Entry of the file or directory; nil for the root directory.
+	e *Entry
+}
+
+// Name returns the base name of the file.
+func (fi fileInfo) Name() string { return fi.name }
+
+// Size returns the size in bytes of the file.
+func (fi fileInfo) Size() int64 {
+	if fi.e == nil {
+		return 0
+	}
+	return fi.e.Size
+}
+
+// Mode returns the file mode bits.
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.IsDir() {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// ModTime returns the zero time; PAK archives do not record modification
+// times.
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+
+// IsDir reports whether the file is a directory.
+func (fi fileInfo) IsDir() bool { return fi.e == nil || fi.e.IsDir() }
+
+// Sys returns the *Entry of the file, or nil for the root directory.
+func (fi fileInfo) Sys() any {
+	if fi.e == nil {
+		return nil
+	}
+	return fi.e
+}
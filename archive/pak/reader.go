@@ -0,0 +1,121 @@
+package pak
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Reader provides random access to the files (and subarchives) contained
+// within a PAK archive. Only the PAK header is read up front; file contents are
+// accessed through section readers of the underlying archive.
+type Reader struct {
+	// Underlying PAK archive.
+	r io.ReaderAt
+	// Archive offsets of the PAK header.
+	archiveOffsets []uint32
+}
+
+// NewReader returns a new reader of the given PAK archive of the specified
+// size.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	archiveOffsets, err := readPAKHeader(r, size)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// the first offset is the size of the PAK header and the last offset is the
+	// size of the archive, so monotonic offsets are within bounds.
+	for i := 1; i < len(archiveOffsets); i++ {
+		if archiveOffsets[i] < archiveOffsets[i-1] {
+			return nil, errors.WithStack(invalidEndOffset(i-1, archiveOffsets[i-1], archiveOffsets[i]))
+		}
+	}
+	return &Reader{r: r, archiveOffsets: archiveOffsets}, nil
+}
+
+// ReadCloser is a reader of a PAK archive file which must be closed after use.
+type ReadCloser struct {
+	// PAK archive file.
+	f *os.File
+	*Reader
+}
+
+// OpenReader opens the given PAK archive for reading.
+func OpenReader(pakPath string) (*ReadCloser, error) {
+	f, err := os.Open(pakPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	r, err := NewReader(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "unable to open PAK archive %q", pakPath)
+	}
+	return &ReadCloser{f: f, Reader: r}, nil
+}
+
+// Close closes the PAK archive file.
+func (rc *ReadCloser) Close() error {
+	return rc.f.Close()
+}
+
+// Len returns the number of files (and subarchives) contained within the
+// archive.
+func (r *Reader) Len() int {
+	return len(r.archiveOffsets) - 1
+}
+
+// Size returns the size in bytes of the archive.
+func (r *Reader) Size() int64 {
+	return int64(r.archiveOffsets[r.Len()])
+}
+
+// Offsets returns the archive offsets of the PAK header; the start offset of
+// each file followed by the end offset of the last file.
+func (r *Reader) Offsets() []uint32 {
+	return append([]uint32(nil), r.archiveOffsets...)
+}
+
+// Offset returns the start offset within the archive of the i:th file.
+func (r *Reader) Offset(i int) int64 {
+	return int64(r.archiveOffsets[i])
+}
+
+// FileSize returns the size in bytes of the i:th file.
+func (r *Reader) FileSize(i int) int64 {
+	return int64(r.archiveOffsets[i+1]) - int64(r.archiveOffsets[i])
+}
+
+// Contents returns a section reader of the entire archive, including the PAK
+// header.
+func (r *Reader) Contents() *io.SectionReader {
+	return io.NewSectionReader(r.r, 0, r.Size())
+}
+
+// File returns a section reader of the contents of the i:th file.
+func (r *Reader) File(i int) *io.SectionReader {
+	return io.NewSectionReader(r.r, r.Offset(i), r.FileSize(i))
+}
+
+// IsSubarchive reports whether the i:th file has a valid PAK header.
+func (r *Reader) IsSubarchive(i int) bool {
+	_, err := readPAKHeader(r.File(i), r.FileSize(i))
+	return err == nil
+}
+
+// Subarchive returns a reader of the i:th file, parsed as a nested PAK archive.
+// The subarchive is read from the section of the parent archive; no contents
+// are copied.
+func (r *Reader) Subarchive(i int) (*Reader, error) {
+	sub, err := NewReader(r.File(i), r.FileSize(i))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open subarchive %d", i)
+	}
+	return sub, nil
+}
@@ -0,0 +1,203 @@
+// Package sniff detects the content type of files contained within PAK
+// archives.
+//
+// Detectors are kept in a registry; detectors registered later take precedence
+// over detectors registered earlier, so more specific content types may be
+// registered on top of more general ones. The following content types are
+// registered by default, in order of increasing precedence:
+//
+//	pal   raw palette of 256 RGBA colours (1024 bytes, without PAK header layout)
+//	pak   PAK archive
+//	zel   ZEL image (PAK header layout, with valid frames)
+//	bmp   BMP image
+//	wav   RIFF WAVE sound
+//	map   MAP file ("MAP\x00" signature)
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/mewspring/pak/archive/pak"
+	"github.com/mewspring/pak/image/zel"
+)
+
+// Type is a content type.
+type Type struct {
+	// Name of the content type (e.g. "zel").
+	Name string
+	// File extension of the content type (e.g. ".zel").
+	Ext string
+}
+
+// Unknown is the content type of files not matched by any detector.
+var Unknown = Type{Name: "unknown", Ext: ".bin"}
+
+// detector detects files of a given content type.
+type detector struct {
+	typ Type
+	// match reports whether the given file of the specified size is of the
+	// content type.
+	match func(r io.ReaderAt, size int64) bool
+}
+
+var (
+	// mu protects detectors.
+	mu sync.RWMutex
+	// detectors holds registered detectors, in order of registration.
+	detectors []detector
+)
+
+// Register registers a detector of the content type with the given name and
+// file extension. The match function reports whether the given file of the
+// specified size is of the content type.
+func Register(name, ext string, match func(r io.ReaderAt, size int64) bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	d := detector{
+		typ:   Type{Name: name, Ext: ext},
+		match: match,
+	}
+	detectors = append(detectors, d)
+}
+
+// Detect returns the content type of the given file of the specified size, or
+// Unknown if no detector matches.
+func Detect(r io.ReaderAt, size int64) Type {
+	mu.RLock()
+	defer mu.RUnlock()
+	for i := len(detectors) - 1; i >= 0; i-- {
+		d := detectors[i]
+		if d.match(r, size) {
+			return d.typ
+		}
+	}
+	return Unknown
+}
+
+// DetectBytes returns the content type of the given file contents, or Unknown
+// if no detector matches.
+func DetectBytes(buf []byte) Type {
+	return Detect(bytes.NewReader(buf), int64(len(buf)))
+}
+
+// Types returns the registered content types, in order of increasing
+// precedence.
+func Types() []Type {
+	mu.RLock()
+	defer mu.RUnlock()
+	var types []Type
+	for _, d := range detectors {
+		types = append(types, d.typ)
+	}
+	return types
+}
+
+func init() {
+	// pal is registered first, and thus checked last, as it is detected by
+	// size rather than by signature.
+	Register("pal", ".pal", isPal)
+	Register("pak", ".pak", isPak)
+	Register("zel", ".zel", isZel)
+	Register("bmp", ".bmp", isBmp)
+	Register("wav", ".wav", isWav)
+	Register("map", ".map", isMap)
+}
+
+// isPal reports whether the given file is a raw palette of 256 RGBA colours.
+//
+// Palettes have no signature, and are thus checked last (see init). Files of
+// 1024 bytes starting with a plausible PAK header size are not palettes, as
+// they are PAK archives or ZEL images which failed validation (e.g. damaged).
+func isPal(r io.ReaderAt, size int64) bool {
+	if size != 256*4 {
+		return false
+	}
+	var rawHdrSize [4]byte
+	if _, err := r.ReadAt(rawHdrSize[:], 0); err != nil {
+		return false
+	}
+	hdrSize := int64(binary.LittleEndian.Uint32(rawHdrSize[:]))
+	return hdrSize < 8 || hdrSize > size || hdrSize%4 != 0
+}
+
+// isPak reports whether the given file is a PAK archive.
+func isPak(r io.ReaderAt, size int64) bool {
+	_, err := pak.NewReader(r, size)
+	return err == nil
+}
+
+// isZel reports whether the given file is a ZEL image.
+//
+// ZEL images share the layout of the PAK header, so the structure of each frame
+// is validated. The frame headers are checked first, as subarchives are cheaply
+// rejected by the PAK header of their first file being read as a zero frame
+// height.
+func isZel(r io.ReaderAt, size int64) bool {
+	zr, err := pak.NewReader(r, size)
+	if err != nil {
+		return false
+	}
+	for i := 0; i < zr.Len(); i++ {
+		if zr.FileSize(i) == 0 {
+			// skip empty frame.
+			continue
+		}
+		var frameHdr [4]byte
+		if _, err := zr.File(i).ReadAt(frameHdr[:], 0); err != nil {
+			return false
+		}
+		frameWidth := binary.LittleEndian.Uint16(frameHdr[0:2])
+		frameHeight := binary.LittleEndian.Uint16(frameHdr[2:4])
+		if frameWidth == 0 || frameHeight == 0 {
+			return false
+		}
+	}
+	buf := make([]byte, size)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return false
+	}
+	return zel.Validate(buf) == nil
+}
+
+// isBmp reports whether the given file is a BMP image.
+func isBmp(r io.ReaderAt, size int64) bool {
+	// file header (14 bytes) followed by DIB header size.
+	var hdr [18]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return false
+	}
+	if string(hdr[0:2]) != "BM" {
+		return false
+	}
+	pixelsOffset := int64(binary.LittleEndian.Uint32(hdr[10:14]))
+	dibHdrSize := int64(binary.LittleEndian.Uint32(hdr[14:18]))
+	switch dibHdrSize {
+	case 12, 40, 52, 56, 108, 124:
+		// BITMAPCOREHEADER, BITMAPINFOHEADER, BITMAPV2INFOHEADER,
+		// BITMAPV3INFOHEADER, BITMAPV4HEADER and BITMAPV5HEADER.
+	default:
+		return false
+	}
+	return pixelsOffset >= 14+dibHdrSize && pixelsOffset <= size
+}
+
+// isWav reports whether the given file is a RIFF WAVE sound.
+func isWav(r io.ReaderAt, size int64) bool {
+	var hdr [12]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return false
+	}
+	return string(hdr[0:4]) == "RIFF" && string(hdr[8:12]) == "WAVE"
+}
+
+// isMap reports whether the given file is a MAP file.
+func isMap(r io.ReaderAt, size int64) bool {
+	var magic [4]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return false
+	}
+	return string(magic[:]) == "MAP\x00"
+}
@@ -0,0 +1,165 @@
+package pak
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Create creates a PAK archive containing the given files (and subarchives),
+// and returns the archive contents.
+//
+// Re-packing the files returned by Extract reproduces the original archive.
+func Create(filesContents [][]byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	for _, fileContents := range filesContents {
+		if err := w.AddFile(fileContents); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Writer writes PAK archives.
+//
+// The PAK header records the offsets of all files contained within the
+// archive, so nothing is written to the underlying writer until Close is
+// invoked on the top-level archive.
+type Writer struct {
+	// Underlying writer; nil for subarchives.
+	w io.Writer
+	// Files (and subarchives) contained within the archive.
+	files []*file
+	// Reports whether the archive has been written.
+	closed bool
+}
+
+// file is a file (or subarchive) contained within a PAK archive.
+type file struct {
+	// File contents; used if sub is nil.
+	contents []byte
+	// Subarchive.
+	sub *Writer
+}
+
+// NewWriter returns a new writer which writes a PAK archive to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// AddFile appends a file with the given contents to the archive. Empty files
+// are valid.
+//
+// The contents are not copied and must not be modified until the archive has
+// been closed.
+func (w *Writer) AddFile(contents []byte) error {
+	if w.closed {
+		return errors.New("add file to closed PAK archive")
+	}
+	w.files = append(w.files, &file{contents: contents})
+	return nil
+}
+
+// AddSubarchive appends a nested PAK archive to the archive, and returns a
+// writer which may be used to add files to the subarchive.
+//
+// The subarchive is written as part of its parent archive; invoking Close on
+// the returned writer is not required.
+func (w *Writer) AddSubarchive() (*Writer, error) {
+	if w.closed {
+		return nil, errors.New("add subarchive to closed PAK archive")
+	}
+	sub := &Writer{}
+	w.files = append(w.files, &file{sub: sub})
+	return sub, nil
+}
+
+// Size returns the size in bytes of the archive, as it would be written by
+// Close.
+func (w *Writer) Size() int64 {
+	size := w.hdrSize()
+	for _, f := range w.files {
+		size += f.size()
+	}
+	return size
+}
+
+// Close writes the PAK archive to the underlying writer. Invoking Close on a
+// subarchive is a no-op.
+func (w *Writer) Close() error {
+	if w.w == nil {
+		return nil
+	}
+	if w.closed {
+		return errors.New("close of closed PAK archive")
+	}
+	w.closed = true
+	return w.writeTo(w.w)
+}
+
+// Offsets returns the archive offsets of the PAK header, as they would be
+// written by Close.
+func (w *Writer) Offsets() ([]uint32, error) {
+	if size := w.Size(); size > math.MaxUint32 {
+		return nil, errors.Errorf("PAK archive too large; expected <= %d bytes, got %d", uint32(math.MaxUint32), size)
+	}
+	archiveOffsets := make([]uint32, 0, len(w.files)+1)
+	off := w.hdrSize()
+	archiveOffsets = append(archiveOffsets, uint32(off))
+	for _, f := range w.files {
+		off += f.size()
+		archiveOffsets = append(archiveOffsets, uint32(off))
+	}
+	return archiveOffsets, nil
+}
+
+// writeTo writes the PAK archive (including subarchives) to the given writer.
+func (w *Writer) writeTo(dst io.Writer) error {
+	// a PAK archive containing no files would have a 4 byte header, which is
+	// rejected by ParsePAKHeader.
+	if len(w.files) == 0 {
+		return errors.New("empty PAK archive; expected at least one file")
+	}
+	// write PAK header.
+	archiveOffsets, err := w.Offsets()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := binary.Write(dst, binary.LittleEndian, archiveOffsets); err != nil {
+		return errors.WithStack(err)
+	}
+	// write contents of files contained within archive.
+	for _, f := range w.files {
+		if f.sub != nil {
+			if err := f.sub.writeTo(dst); err != nil {
+				return errors.WithStack(err)
+			}
+			continue
+		}
+		if _, err := dst.Write(f.contents); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// hdrSize returns the size in bytes of the PAK header; one offset for the start
+// of each file, and one for the end of the archive.
+func (w *Writer) hdrSize() int64 {
+	return int64(len(w.files)+1) * 4
+}
+
+// size returns the size in bytes of the file (or subarchive).
+func (f *file) size() int64 {
+	if f.sub != nil {
+		return f.sub.Size()
+	}
+	return int64(len(f.contents))
+}
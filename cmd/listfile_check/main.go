@@ -0,0 +1,277 @@
+// listfile_check reports the listfile coverage of PAK archives, without
+// extracting any files.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/mewkiz/pkg/term"
+	"github.com/mewspring/pak/archive/pak"
+	"github.com/mewspring/pak/archive/pak/listfile"
+	"github.com/mewspring/pak/archive/pak/pakfs"
+	"github.com/mewspring/pak/archive/pak/sniff"
+	"github.com/pkg/errors"
+)
+
+var (
+	// dbg is a logger with the "listfile_check:" prefix which logs debug
+	// messages to standard error.
+	dbg = log.New(os.Stderr, term.MagentaBold("listfile_check:")+" ", 0)
+	// warn is a logger with the "listfile_check:" prefix which logs warning
+	// messages to standard error.
+	warn = log.New(os.Stderr, term.RedBold("listfile_check:")+" ", log.Lshortfile)
+)
+
+func usage() {
+	const usage = "Usage: listfile_check [OPTIONS]... FILE.pak..."
+	fmt.Fprintln(os.Stderr, usage)
+	flag.PrintDefaults()
+}
+
+func main() {
+	// parse command line arguments.
+	var (
+		listfilePath string
+		jsonOutput   bool
+	)
+	flag.StringVar(&listfilePath, "listfile", "", "listfile path (JSON format)")
+	flag.BoolVar(&jsonOutput, "json", false, "output report in JSON format")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	lf, err := listfile.ParseFile(listfilePath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	report, err := checkListfile(flag.Args(), lf)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if jsonOutput {
+		if err := writeJSON(os.Stdout, report); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	writeText(os.Stdout, report)
+}
+
+// Report is a listfile coverage report of PAK archives.
+type Report struct {
+	// Coverage of each (sub)archive, sorted by directory path.
+	Archives []*ArchiveReport `json:"archives"`
+	// Default paths of files not named by the listfile.
+	Unnamed []string `json:"unnamed"`
+	// Default paths of files by file path, for file paths that several files
+	// resolve to.
+	Duplicates map[string][]string `json:"duplicates"`
+	// Default paths of the listfile which are not present in the archives.
+	Dangling []string `json:"dangling"`
+	// Named files whose extension disagrees with their contents.
+	Mismatches []*Mismatch `json:"mismatches"`
+}
+
+// ArchiveReport is the listfile coverage of the files directly contained
+// within a (sub)archive.
+type ArchiveReport struct {
+	// Directory path of the (sub)archive (e.g. "X/cursors").
+	Dir string `json:"dir"`
+	// Number of files named by the listfile.
+	Named int `json:"named"`
+	// Number of files not named by the listfile.
+	Unnamed int `json:"unnamed"`
+}
+
+// Mismatch is a named file whose extension disagrees with its contents.
+type Mismatch struct {
+	// File path (e.g. "X/cursors/hand.zel").
+	Path string `json:"path"`
+	// Default path (e.g. "X/cursors/archive_0000.pak").
+	RawPath string `json:"raw_path"`
+	// Content type detected from the contents (e.g. "wav" or "unknown").
+	Sniffed string `json:"sniffed"`
+}
+
+// checkListfile checks the listfile coverage of the given PAK archives.
+//
+// Every non-empty file of the archive trees is checked, including files
+// shadowed in the file system view of pakfs by later files of the same name.
+func checkListfile(pakPaths []string, lf *listfile.Listfile) (*Report, error) {
+	c := &checker{
+		lf:       lf,
+		report:   &Report{},
+		archives: make(map[string]*ArchiveReport),
+	}
+	for _, pakPath := range pakPaths {
+		dbg.Printf("checking %q", pakPath)
+		rc, err := pak.OpenReader(pakPath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = c.checkArchive(rc.Reader, pathutil.TrimExt(filepath.Base(pakPath)))
+		rc.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to check %q", pakPath)
+		}
+	}
+	report := c.report
+	for _, archive := range c.archives {
+		report.Archives = append(report.Archives, archive)
+	}
+	sort.Slice(report.Archives, func(i, j int) bool {
+		return report.Archives[i].Dir < report.Archives[j].Dir
+	})
+	sort.Slice(report.Mismatches, func(i, j int) bool {
+		return report.Mismatches[i].Path < report.Mismatches[j].Path
+	})
+	check := lf.Check(c.rawPaths)
+	report.Unnamed = check.Unnamed
+	report.Duplicates = check.Duplicates
+	report.Dangling = check.Dangling
+	return report, nil
+}
+
+// checker checks the listfile coverage of PAK archive trees.
+type checker struct {
+	// Listfile mapping from default paths to file paths.
+	lf *listfile.Listfile
+	// Listfile coverage report.
+	report *Report
+	// Coverage of each (sub)archive, by directory path.
+	archives map[string]*ArchiveReport
+	// Default paths of every file checked.
+	rawPaths []string
+}
+
+// checkArchive checks the listfile coverage of the files of the given
+// (sub)archive, of the given directory path (e.g. "X/cursors"), recursing into
+// subarchives exposed as directories by pakfs.
+func (c *checker) checkArchive(r *pak.Reader, dirPath string) error {
+	for i := 0; i < r.Len(); i++ {
+		if r.FileSize(i) == 0 {
+			// skip empty file.
+			continue
+		}
+		rawPath := path.Join(dirPath, pakfs.RawName(r, i))
+		c.rawPaths = append(c.rawPaths, rawPath)
+		archive, ok := c.archives[dirPath]
+		if !ok {
+			archive = &ArchiveReport{Dir: dirPath}
+			c.archives[dirPath] = archive
+		}
+		filePath, named := pakfs.FilePath(r, i, dirPath, c.lf)
+		if named {
+			archive.Named++
+			if mismatch := checkExt(filePath, rawPath, r.File(i)); mismatch != nil {
+				c.report.Mismatches = append(c.report.Mismatches, mismatch)
+			}
+		} else {
+			archive.Unnamed++
+		}
+		if pakfs.IsDir(r, i, filePath) {
+			sub, err := r.Subarchive(i)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if err := c.checkArchive(sub, pathutil.TrimExt(filePath)); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkExt checks whether the extension of the given named file agrees with its
+// contents. A nil mismatch is returned if it does, or if the extension is not
+// that of a registered content type.
+func checkExt(filePath, rawPath string, sr *io.SectionReader) *Mismatch {
+	ext := strings.ToLower(path.Ext(filePath))
+	if !isSniffedExt(ext) {
+		return nil
+	}
+	typ := sniff.Detect(sr, sr.Size())
+	if typ.Ext == ext {
+		return nil
+	}
+	mismatch := &Mismatch{
+		Path:    filePath,
+		RawPath: rawPath,
+		Sniffed: typ.Name,
+	}
+	return mismatch
+}
+
+// isSniffedExt reports whether the given file extension is that of a registered
+// content type.
+func isSniffedExt(ext string) bool {
+	for _, typ := range sniff.Types() {
+		if typ.Ext == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON writes the given report in JSON format.
+func writeJSON(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(report); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeText writes the given report in human-readable format.
+func writeText(w io.Writer, report *Report) {
+	named, total := 0, 0
+	for _, archive := range report.Archives {
+		n := archive.Named + archive.Unnamed
+		fmt.Fprintf(w, "%-48s %5d/%-5d named\n", archive.Dir, archive.Named, n)
+		named += archive.Named
+		total += n
+	}
+	fmt.Fprintf(w, "%-48s %5d/%-5d named\n", "total", named, total)
+	if len(report.Unnamed) > 0 {
+		fmt.Fprintf(w, "\nunnamed files (%d):\n", len(report.Unnamed))
+		for _, rawPath := range report.Unnamed {
+			fmt.Fprintf(w, "\t%s\n", rawPath)
+		}
+	}
+	if len(report.Duplicates) > 0 {
+		var names []string
+		for name := range report.Duplicates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "\nduplicate file paths (%d):\n", len(names))
+		for _, name := range names {
+			fmt.Fprintf(w, "\t%s <- %s\n", name, strings.Join(report.Duplicates[name], ", "))
+		}
+	}
+	if len(report.Dangling) > 0 {
+		fmt.Fprintf(w, "\ndangling listfile entries (%d):\n", len(report.Dangling))
+		for _, rawPath := range report.Dangling {
+			fmt.Fprintf(w, "\t%s\n", rawPath)
+		}
+	}
+	if len(report.Mismatches) > 0 {
+		fmt.Fprintf(w, "\nextension mismatches (%d):\n", len(report.Mismatches))
+		for _, mismatch := range report.Mismatches {
+			fmt.Fprintf(w, "\t%s (%s): contents %s\n", mismatch.Path, mismatch.RawPath, mismatch.Sniffed)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+// pak_build builds a PAK archive from a directory tree laid out as dumped by
+// pak_dump (e.g. "_dump_/X"), rebuilding nested subarchives bottom-up.
+//
+// The order of files is taken from the manifest written by pak_dump if present,
+// and derived from the indices of default paths (e.g. "X/archive_0012.pak" and
+// "X/core/file_0002.bin") of the listfile and of unnamed files otherwise. Files
+// skipped by pak_dump for being empty are added back as empty files; note that
+// trailing empty files of an archive are only known from the manifest. Files
+// shadowed by later files of the same name are located by index, as dumped by
+// pak_dump (e.g. "X/towner_9_dir~12.zel").
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/mewkiz/pkg/term"
+	"github.com/mewspring/pak/archive/pak"
+	"github.com/mewspring/pak/archive/pak/listfile"
+	"github.com/mewspring/pak/archive/pak/manifest"
+	"github.com/mewspring/pak/archive/pak/pakfs"
+	"github.com/pkg/errors"
+)
+
+var (
+	// dbg is a logger with the "pak_build:" prefix which logs debug messages to
+	// standard error.
+	dbg = log.New(os.Stderr, term.MagentaBold("pak_build:")+" ", 0)
+	// warn is a logger with the "pak_build:" prefix which logs warning messages
+	// to standard error.
+	warn = log.New(os.Stderr, term.RedBold("pak_build:")+" ", log.Lshortfile)
+)
+
+func usage() {
+	const usage = "Usage: pak_build [OPTIONS]... DIR"
+	fmt.Fprintln(os.Stderr, usage)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Builds the PAK archive of DIR (e.g. _dump_/X), within the output directory of pak_dump.")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+func main() {
+	// parse command line arguments.
+	var (
+		manifestPath string
+		listfilePath string
+		output       string
+		dryRun       bool
+	)
+	flag.StringVar(&manifestPath, "manifest", "", "manifest path (JSON format; default: manifest.json of dump directory, if present)")
+	flag.StringVar(&listfilePath, "listfile", "", "listfile path (JSON format); used if no manifest is present")
+	flag.StringVar(&output, "o", "", "output PAK archive")
+	flag.BoolVar(&dryRun, "n", false, "dry-run; print offset tables instead of writing output")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 || (len(output) == 0 && !dryRun) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	archiveDir := filepath.Clean(flag.Arg(0))
+	dumpDir := filepath.Dir(archiveDir)
+	relDir := filepath.Base(archiveDir)
+	// locate manifest.
+	if len(manifestPath) == 0 {
+		defaultPath := filepath.Join(dumpDir, "manifest.json")
+		if _, err := os.Stat(defaultPath); err == nil {
+			manifestPath = defaultPath
+		}
+	}
+	// plan PAK archive.
+	b := &builder{dumpDir: dumpDir}
+	var top *archive
+	if len(manifestPath) > 0 {
+		dbg.Printf("using manifest %q", manifestPath)
+		m, err := manifest.ParseFile(manifestPath)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if top, err = b.planManifest(m, relDir); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	} else {
+		lf, err := listfile.ParseFile(listfilePath)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if top, err = b.planDir(lf, relDir, relDir+".pak"); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	}
+	// build PAK archive.
+	if dryRun {
+		if err := b.printOffsets(os.Stdout, top); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	if err := b.writeArchive(output, top); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// builder builds PAK archives from a directory tree dumped by pak_dump.
+type builder struct {
+	// Root output directory of pak_dump (e.g. "_dump_").
+	dumpDir string
+}
+
+// archive is a planned PAK (sub)archive.
+type archive struct {
+	// Archive path, relative to the dump directory (e.g. "X.PAK" or
+	// "X/core.pak").
+	path string
+	// Files (and subarchives) of the archive, in order.
+	files []*entry
+	// PAK writer of the archive; set when files are added.
+	w *pak.Writer
+}
+
+// entry is a planned file (or subarchive) of a PAK archive.
+type entry struct {
+	// File path, relative to the dump directory; empty for empty files.
+	path string
+	// Subarchive; non-nil if the entry is rebuilt from a directory.
+	sub *archive
+}
+
+// --- [ manifest ] ------------------------------------------------------------
+
+// planManifest plans the PAK archive of the given directory (e.g. "X"), using
+// the order of files recorded by the manifest.
+func (b *builder) planManifest(m *manifest.Manifest, relDir string) (*archive, error) {
+	archives := m.Archives()
+	for archivePath := range archives {
+		if pathutil.TrimExt(archivePath) == relDir {
+			return b.planManifestArchive(archives, archivePath)
+		}
+	}
+	return nil, errors.Errorf("unable to locate archive of directory %q in manifest", relDir)
+}
+
+// planManifestArchive plans the given PAK archive of the manifest, and
+// recursively its subarchives.
+func (b *builder) planManifestArchive(archives map[string][]*manifest.File, archivePath string) (*archive, error) {
+	a := &archive{path: archivePath}
+	files := archives[archivePath]
+	if len(files) == 0 {
+		return nil, errors.Errorf("no files of archive %q in manifest", archivePath)
+	}
+	byIndex := make(map[int]*manifest.File)
+	for _, f := range files {
+		if prev, ok := byIndex[f.Index]; ok {
+			return nil, errors.Errorf("file %d of archive %q recorded twice in manifest (%q and %q)", f.Index, archivePath, prev.Path, f.Path)
+		}
+		byIndex[f.Index] = f
+	}
+	byPath := make(map[string]int)
+	n := files[len(files)-1].Index + 1
+	for i := 0; i < n; i++ {
+		f, ok := byIndex[i]
+		switch {
+		case !ok:
+			warn.Printf("file %d of archive %q not recorded in manifest; adding empty file", i, archivePath)
+			a.files = append(a.files, &entry{})
+		case f.Size == 0:
+			// add back empty file skipped by pak_dump.
+			a.files = append(a.files, &entry{})
+		case f.Subarchive && len(archives[f.Path]) > 0:
+			sub, err := b.planManifestArchive(archives, f.Path)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			a.files = append(a.files, &entry{path: f.Path, sub: sub})
+		default:
+			// files sharing the same path are dumped by index (e.g.
+			// "X/towner_9_dir~12.zel"); the contents of files sharing a path
+			// in the manifest are unknown.
+			if prev, ok := byPath[f.Path]; ok {
+				return nil, errors.Errorf("files %d and %d of archive %q share path %q in manifest; dump archive again to output files by index", prev, i, archivePath, f.Path)
+			}
+			byPath[f.Path] = i
+			a.files = append(a.files, &entry{path: f.Path})
+		}
+	}
+	return a, nil
+}
+
+// --- [ listfile ] ------------------------------------------------------------
+
+// rawNameRegexp matches the default file names (without extension) assigned by
+// pak_dump to unnamed files, optionally followed by the index of files shadowed
+// by later files of the same name (e.g. "sound_0015~15").
+var rawNameRegexp = regexp.MustCompile(`^(archive|file|sound)_([0-9]+)(?:~([0-9]+))?$`)
+
+// rawIndex returns the file index of the given default path (e.g. 12 for
+// "X/archive_0012.pak"), and a boolean indicating success. The index of
+// shadowed files is used if present (e.g. 3 for "X/sounds/sound_0015~3.wav").
+func rawIndex(rawPath string) (int, bool) {
+	subs := rawNameRegexp.FindStringSubmatch(pathutil.TrimExt(path.Base(rawPath)))
+	if subs == nil {
+		return 0, false
+	}
+	s := subs[2]
+	if len(subs[3]) > 0 {
+		s = subs[3]
+	}
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+	return index, true
+}
+
+// planDir plans the PAK archive of the given directory (e.g. "X" or
+// "X/core"), deriving the order of files from the indices of default paths.
+//
+// Files named by the listfile are located by the index of their default path,
+// and unnamed files by the index of their file name. Subarchives are rebuilt
+// from their directories, in favour of subarchive files kept by pak_dump.
+func (b *builder) planDir(lf *listfile.Listfile, relDir, archivePath string) (*archive, error) {
+	byIndex := make(map[int]*entry)
+	add := func(index int, e *entry) error {
+		if prev, ok := byIndex[index]; ok && prev.path != e.path {
+			return errors.Errorf("file %d of archive %q located at both %q and %q", index, archivePath, prev.path, e.path)
+		}
+		byIndex[index] = e
+		return nil
+	}
+	// locateEntry returns the file (or subarchive) of the given file path, or
+	// nil if not present.
+	locateEntry := func(name string) (*entry, error) {
+		if path.Ext(name) == ".pak" {
+			subDir := pathutil.TrimExt(name)
+			if isDir(filepath.Join(b.dumpDir, filepath.FromSlash(subDir))) {
+				sub, err := b.planDir(lf, subDir, name)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				return &entry{path: name, sub: sub}, nil
+			}
+		}
+		if isFile(filepath.Join(b.dumpDir, filepath.FromSlash(name))) {
+			return &entry{path: name}, nil
+		}
+		return nil, nil
+	}
+	// files named by the listfile.
+	named := make(map[int]bool)
+	lastIndex := make(map[string]int)
+	var rawPaths []string
+	for _, rawPath := range lf.Keys() {
+		if path.Dir(rawPath) != relDir {
+			continue
+		}
+		index, ok := rawIndex(rawPath)
+		if !ok {
+			warn.Printf("skipping listfile entry %q; invalid default path", rawPath)
+			continue
+		}
+		named[index] = true
+		rawPaths = append(rawPaths, rawPath)
+		name := path.Join(relDir, path.Base(lf.Resolve(rawPath)))
+		if prev, ok := lastIndex[name]; !ok || index > prev {
+			lastIndex[name] = index
+		}
+	}
+	for _, rawPath := range rawPaths {
+		index, _ := rawIndex(rawPath)
+		name := path.Join(relDir, path.Base(lf.Resolve(rawPath)))
+		var e *entry
+		if lastIndex[name] != index {
+			// file shadowed by later named file of the same name; dumped by
+			// index (e.g. "X/towner_9_dir~12.zel").
+			name = pakfs.ShadowedPath(name, index)
+		} else if j, ok := rawIndex(name); ok && !named[j] && j > index {
+			// file possibly shadowed by later unnamed file of the same default
+			// file name (e.g. "X/sounds/sound_0015.wav" of file 15).
+			shadowedName := pakfs.ShadowedPath(name, index)
+			var err error
+			if e, err = locateEntry(shadowedName); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if e != nil {
+				name = shadowedName
+			}
+		}
+		if e == nil {
+			var err error
+			if e, err = locateEntry(name); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		if e == nil {
+			// empty files are skipped by pak_dump.
+			dbg.Printf("file %q (%q) not present; adding empty file", name, rawPath)
+			continue
+		}
+		if err := add(index, e); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	// unnamed files.
+	dirEntries, err := os.ReadDir(filepath.Join(b.dumpDir, filepath.FromSlash(relDir)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, dirEntry := range dirEntries {
+		name := path.Join(relDir, dirEntry.Name())
+		index, ok := rawIndex(name)
+		if !ok || named[index] {
+			// skip named file.
+			continue
+		}
+		if dirEntry.IsDir() {
+			// unnamed subarchive directory (e.g. "X/archive_0012").
+			name += ".pak"
+		} else if path.Ext(name) == ".pak" && isDir(filepath.Join(b.dumpDir, filepath.FromSlash(pathutil.TrimExt(name)))) {
+			// skip unnamed subarchive kept by pak_dump; rebuilt from directory.
+			continue
+		}
+		if last, ok := lastIndex[name]; ok && last > index {
+			// skip named file of the default file name of an earlier unnamed
+			// file (e.g. "X/sounds/sound_0015.wav" of file 44); the unnamed
+			// file is dumped by index (e.g. "X/sounds/sound_0015~15.wav").
+			continue
+		}
+		e, err := locateEntry(name)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := add(index, e); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if len(byIndex) == 0 {
+		return nil, errors.Errorf("no files of archive %q located in %q", archivePath, relDir)
+	}
+	// add files in order, adding back empty files skipped by pak_dump.
+	var indices []int
+	for index := range byIndex {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	a := &archive{path: archivePath}
+	for i := 0; i <= indices[len(indices)-1]; i++ {
+		e, ok := byIndex[i]
+		if !ok {
+			e = &entry{}
+		}
+		a.files = append(a.files, e)
+	}
+	return a, nil
+}
+
+// isDir reports whether the given path is a directory.
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// isFile reports whether the given path is a regular file.
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// --- [ build ] ---------------------------------------------------------------
+
+// addFiles adds the files (and subarchives) of the given planned archive to w,
+// reading file contents from the dump directory.
+func (b *builder) addFiles(w *pak.Writer, a *archive) error {
+	a.w = w
+	for _, e := range a.files {
+		switch {
+		case e.sub != nil:
+			sub, err := w.AddSubarchive()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if err := b.addFiles(sub, e.sub); err != nil {
+				return errors.WithStack(err)
+			}
+		case len(e.path) == 0:
+			if err := w.AddFile(nil); err != nil {
+				return errors.WithStack(err)
+			}
+		default:
+			contents, err := ioutil.ReadFile(filepath.Join(b.dumpDir, filepath.FromSlash(e.path)))
+			if err != nil {
+				return errors.Wrapf(err, "unable to read file of archive %q", a.path)
+			}
+			if err := w.AddFile(contents); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeArchive writes the given planned PAK archive to the output path.
+func (b *builder) writeArchive(output string, a *archive) (err error) {
+	f, err := os.Create(output)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if e := f.Close(); err == nil {
+			err = errors.WithStack(e)
+		}
+	}()
+	bw := bufio.NewWriter(f)
+	w := pak.NewWriter(bw)
+	if err := b.addFiles(w, a); err != nil {
+		return errors.WithStack(err)
+	}
+	dbg.Printf("creating %q", output)
+	if err := w.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// printOffsets prints the offset tables of the given planned PAK archive and
+// its subarchives, as they would be written.
+func (b *builder) printOffsets(out io.Writer, a *archive) error {
+	w := pak.NewWriter(ioutil.Discard)
+	if err := b.addFiles(w, a); err != nil {
+		return errors.WithStack(err)
+	}
+	return printArchiveOffsets(out, a)
+}
+
+// printArchiveOffsets prints the offset table of the given PAK archive, followed
+// by the offset tables of its subarchives.
+func printArchiveOffsets(out io.Writer, a *archive) error {
+	archiveOffsets, err := a.w.Offsets()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Fprintf(out, "%s (%d files, %d bytes)\n", a.path, len(a.files), a.w.Size())
+	fmt.Fprintf(out, "\t%5s  %-10s  %-10s  %10s  %s\n", "index", "start", "end", "size", "path")
+	for i, e := range a.files {
+		start, end := archiveOffsets[i], archiveOffsets[i+1]
+		name := e.path
+		switch {
+		case e.sub != nil:
+			name += " (subarchive)"
+		case len(name) == 0:
+			name = "(empty)"
+		}
+		fmt.Fprintf(out, "\t%5d  0x%08X  0x%08X  %10d  %s\n", i, start, end, end-start, name)
+	}
+	for _, e := range a.files {
+		if e.sub == nil {
+			continue
+		}
+		fmt.Fprintln(out)
+		if err := printArchiveOffsets(out, e.sub); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// pak_diff reports the differences between two PAK archives (e.g. two builds of
+// X.PAK), matching files by their listfile names.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewkiz/pkg/term"
+	"github.com/mewspring/pak/archive/pak"
+	"github.com/mewspring/pak/archive/pak/listfile"
+	"github.com/mewspring/pak/archive/pak/pakdiff"
+	"github.com/mewspring/pak/archive/pak/pakfs"
+	"github.com/pkg/errors"
+)
+
+// dbg is a logger with the "pak_diff:" prefix which logs debug messages to
+// standard error.
+var dbg = log.New(os.Stderr, term.MagentaBold("pak_diff:")+" ", 0)
+
+func usage() {
+	const usage = "Usage: pak_diff [OPTIONS]... OLD.pak NEW.pak"
+	fmt.Fprintln(os.Stderr, usage)
+	flag.PrintDefaults()
+}
+
+func main() {
+	// parse command line arguments.
+	var (
+		listfilePath string
+		jsonOutput   bool
+		pakName      string
+	)
+	flag.StringVar(&listfilePath, "listfile", "", "listfile path (JSON format)")
+	flag.BoolVar(&jsonOutput, "json", false, "output report in JSON format")
+	flag.StringVar(&pakName, "name", "", "archive name used for listfile lookup of both archives (default: file name of OLD.pak)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	oldPakPath, newPakPath := flag.Arg(0), flag.Arg(1)
+	if len(pakName) == 0 {
+		pakName = filepath.Base(oldPakPath)
+	}
+	lf, err := listfile.ParseFile(listfilePath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	report, err := diffPakFiles(oldPakPath, newPakPath, pakName, lf)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if jsonOutput {
+		if err := writeJSON(os.Stdout, report); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	writeText(os.Stdout, report)
+}
+
+// diffPakFiles returns the differences between the given PAK archive files,
+// both named pakName for listfile lookup.
+func diffPakFiles(oldPakPath, newPakPath, pakName string, lf *listfile.Listfile) (*pakdiff.Report, error) {
+	dbg.Printf("comparing %q with %q", oldPakPath, newPakPath)
+	oldRC, err := pak.OpenReader(oldPakPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer oldRC.Close()
+	newRC, err := pak.OpenReader(newPakPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer newRC.Close()
+	oldFS := pakfs.New(oldRC.Reader, pakName, lf)
+	newFS := pakfs.New(newRC.Reader, pakName, lf)
+	report, err := pakdiff.Diff(oldFS, newFS)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to compare %q with %q", oldPakPath, newPakPath)
+	}
+	return report, nil
+}
+
+// writeJSON writes the given report in JSON format.
+func writeJSON(w io.Writer, report *pakdiff.Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(report); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeText writes the given report in human-readable format.
+func writeText(w io.Writer, report *pakdiff.Report) {
+	counts := make(map[pakdiff.Kind]int)
+	for _, change := range report.Changes {
+		counts[change.Kind]++
+		filePath := change.Path
+		if len(change.OldPath) > 0 {
+			// file of different path (e.g. "X/foo/file_0012.bin -> X/foo/file_0012.zel").
+			filePath = fmt.Sprintf("%s -> %s", change.OldPath, change.Path)
+		}
+		switch change.Kind {
+		case pakdiff.Added:
+			fmt.Fprintf(w, "added    %s (%d bytes)\n", filePath, change.NewSize)
+		case pakdiff.Removed:
+			fmt.Fprintf(w, "removed  %s (%d bytes)\n", filePath, change.OldSize)
+		case pakdiff.Resized:
+			fmt.Fprintf(w, "resized  %s (%d -> %d bytes)\n", filePath, change.OldSize, change.NewSize)
+		case pakdiff.Changed:
+			fmt.Fprintf(w, "changed  %s (%d bytes)\n", filePath, change.NewSize)
+		case pakdiff.Renamed:
+			fmt.Fprintf(w, "renamed  %s (%d bytes)\n", filePath, change.NewSize)
+		}
+		if len(change.Frames) > 0 {
+			var frames []string
+			for _, frame := range change.Frames {
+				frames = append(frames, fmt.Sprint(frame))
+			}
+			fmt.Fprintf(w, "\tframes: %s\n", strings.Join(frames, ", "))
+		}
+		if len(change.Layers) > 0 {
+			fmt.Fprintf(w, "\tlayers: %s\n", strings.Join(change.Layers, ", "))
+		}
+	}
+	fmt.Fprintf(w, "\n%d added, %d removed, %d resized, %d changed, %d renamed\n", counts[pakdiff.Added], counts[pakdiff.Removed], counts[pakdiff.Resized], counts[pakdiff.Changed], counts[pakdiff.Renamed])
+}
@@ -6,18 +6,32 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
-	"github.com/mewkiz/pkg/jsonutil"
 	"github.com/mewkiz/pkg/pathutil"
 	"github.com/mewkiz/pkg/term"
 	"github.com/mewspring/pak/archive/pak"
+	"github.com/mewspring/pak/archive/pak/export"
+	"github.com/mewspring/pak/archive/pak/listfile"
+	"github.com/mewspring/pak/archive/pak/manifest"
+	"github.com/mewspring/pak/archive/pak/pakfs"
+	"github.com/mewspring/pak/archive/pak/sniff"
 	"github.com/pkg/errors"
 )
 
@@ -38,56 +52,246 @@ func usage() {
 
 func main() {
 	// parse command line arguments.
-	var listfilePath string
+	var (
+		listfilePath string
+		njobs        int
+		only         stringsFlag
+		outDir       string
+		keep         string
+		noManifest   bool
+		format       string
+	)
 	flag.StringVar(&listfilePath, "listfile", "", "listfile path (JSON format)")
+	flag.StringVar(&outDir, "o", "_dump_", "output directory (or output file with -format; default _dump_.zip or _dump_.tar, - for standard output)")
+	flag.StringVar(&keep, "keep", keepUnnamed, "keep PAK subarchives after extracting files (all, none or unnamed)")
+	flag.BoolVar(&noManifest, "no-manifest", false, "do not write manifest.json to output directory")
+	flag.IntVar(&njobs, "j", runtime.NumCPU(), "number of concurrent extraction jobs")
+	flag.StringVar(&format, "format", "", "export files to a single archive of the given format (zip or tar) instead of an output directory")
+	flag.Var(&only, "only", "only extract files matching glob pattern (e.g. 'X/monsters/bull/**'); may be repeated")
 	flag.Usage = usage
 	flag.Parse()
-	if flag.NArg() == 0 {
+	if flag.NArg() == 0 || njobs < 1 {
 		flag.Usage()
 		os.Exit(1)
 	}
-	listfile, err := parseListfile(listfilePath)
+	switch keep {
+	case keepAll, keepNone, keepUnnamed:
+		// valid keep mode.
+	default:
+		log.Fatalf("invalid -keep mode %q; expected all, none or unnamed", keep)
+	}
+	switch export.Format(format) {
+	case "", export.Zip, export.Tar:
+		// valid format.
+	default:
+		log.Fatalf("invalid -format %q; expected zip or tar", format)
+	}
+	lf, err := listfile.ParseFile(listfilePath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	sel, err := newSelection(only)
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
+	if len(format) > 0 {
+		// export files to a single zip or tar archive.
+		outPath := outDir
+		explicit := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			explicit[f.Name] = true
+		})
+		if explicit["keep"] {
+			log.Fatal("invalid use of -keep with -format; subarchives are exported as directories")
+		}
+		if !explicit["o"] {
+			outPath = "_dump_." + format
+		}
+		if err := exportPakFiles(flag.Args(), outPath, export.Format(format), lf, sel); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	d := newDumper(ctx, njobs, outDir, keep, lf, sel)
 	for _, pakPath := range flag.Args() {
-		if err := dumpPakArchive(pakPath, rootDumpDir, listfile); err != nil {
+		pakPath := pakPath
+		d.spawn(func() error {
+			return d.dumpPakFile(pakPath)
+		})
+	}
+	if err := d.wait(); err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if !noManifest {
+		if err := d.writeManifest(); err != nil {
 			log.Fatalf("%+v", err)
 		}
 	}
 }
 
+// Keep modes, specifying which PAK subarchives to keep after extracting files.
+//
+// Note: the top level PAK archive is never modified.
 const (
-	// rootDumpDir specifies the top-level output directory.
-	rootDumpDir = "_dump_"
-	// keepSubarchive specifies whether to keep PAK (sub)archives after
-	// extracting files.
-	//
-	// Note: the top level PAK archive is always kept.
-	keepSubarchive = false
+	// Keep all subarchives.
+	keepAll = "all"
+	// Keep no subarchives.
+	keepNone = "none"
+	// Keep subarchives not named by the listfile; unnamed subarchives are
+	// probably subdirectories that have not yet been named.
+	keepUnnamed = "unnamed"
 )
 
-// dumpPakArchive dumps the given PAK archive to the specified output directory.
-func dumpPakArchive(pakPath, dumpDir string, listfile map[string]string) error {
-	// parse PAK archive.
-	dbg.Printf("extracting %q", pakPath)
-	filesContents, err := pak.Extract(pakPath)
-	if err != nil {
+// manifestName specifies the file name of the manifest within the output
+// directory.
+const manifestName = "manifest.json"
+
+// dumper dumps PAK archives concurrently, using a bounded number of jobs. Each
+// job dumps the files of one (sub)archive, and spawns new jobs to dump its
+// subarchives. The first error encountered cancels the remaining jobs.
+type dumper struct {
+	// Root output directory.
+	outDir string
+	// Keep mode of PAK subarchives.
+	keep string
+	// Listfile used to name files.
+	lf *listfile.Listfile
+	// Selection of files to dump.
+	sel selection
+	// Parent context; cancelled on interrupt.
+	parent context.Context
+	// Context of jobs; cancelled on first error.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Semaphore limiting the number of concurrently running jobs.
+	sem chan struct{}
+	// Spawned jobs.
+	wg sync.WaitGroup
+	// First error encountered.
+	errOnce sync.Once
+	err     error
+	// Manifest of dumped files; protected by mu.
+	mu       sync.Mutex
+	manifest *manifest.Manifest
+}
+
+// newDumper returns a new dumper running at most njobs jobs concurrently.
+func newDumper(parent context.Context, njobs int, outDir, keep string, lf *listfile.Listfile, sel selection) *dumper {
+	ctx, cancel := context.WithCancel(parent)
+	return &dumper{
+		outDir:   outDir,
+		keep:     keep,
+		manifest: &manifest.Manifest{},
+		lf:       lf,
+		sel:      sel,
+		parent:   parent,
+		ctx:      ctx,
+		cancel:   cancel,
+		sem:      make(chan struct{}, njobs),
+	}
+}
+
+// spawn spawns the given job, which is run once a job slot is available unless
+// the dumper has been cancelled.
+func (d *dumper) spawn(job func() error) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		select {
+		case d.sem <- struct{}{}:
+			defer func() { <-d.sem }()
+		case <-d.ctx.Done():
+			return
+		}
+		if d.ctx.Err() != nil {
+			return
+		}
+		if err := job(); err != nil {
+			d.fail(err)
+		}
+	}()
+}
+
+// fail records the given error if it is the first error encountered, and
+// cancels the remaining jobs.
+func (d *dumper) fail(err error) {
+	d.errOnce.Do(func() {
+		d.err = err
+		d.cancel()
+	})
+}
+
+// wait waits for all jobs to finish, and returns the first error encountered.
+// If the parent context was cancelled, its error is returned.
+func (d *dumper) wait() error {
+	d.wg.Wait()
+	d.cancel()
+	if d.err != nil {
+		return d.err
+	}
+	if err := d.parent.Err(); err != nil {
 		return errors.WithStack(err)
 	}
-	// create output directory.
-	pakName := pathutil.FileName(pakPath)
-	pakNameWithoutExt := pathutil.TrimExt(pakName)
-	dstDir := filepath.Join(dumpDir, pakNameWithoutExt)
-	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+	return nil
+}
+
+// dumpPakFile dumps the given PAK archive file to the root output directory.
+func (d *dumper) dumpPakFile(pakPath string) error {
+	buf, err := ioutil.ReadFile(pakPath)
+	if err != nil {
 		return errors.WithStack(err)
 	}
-	reverseListfile := make(map[string]string)
-	for key, val := range listfile {
-		reverseListfile[val] = key
+	a := &archive{
+		pakPath: pakPath,
+		relPath: filepath.Base(pakPath),
+		buf:     buf,
+		dumpDir: d.outDir,
+		all:     d.sel.isEmpty(),
 	}
-	// output PAK subarchives (and files).
-	var subarchivePaths []string
+	return d.dumpPakArchive(a)
+}
+
+// archive is a PAK (sub)archive to dump.
+type archive struct {
+	// Path of the archive file (e.g. "X.PAK" or "_dump_/X/core.pak").
+	pakPath string
+	// Path of the archive as recorded in the manifest (e.g. "X.PAK" or
+	// "X/core.pak").
+	relPath string
+	// Offset in bytes of the archive within the top-level archive.
+	absOffset int64
+	// Archive contents.
+	buf []byte
+	// Output directory of the parent archive.
+	dumpDir string
+	// Reports whether every file of the archive is selected; otherwise, only
+	// files matching the selection of the dumper are dumped.
+	all bool
+}
+
+// dumpPakArchive dumps the given PAK archive to the output directory of its
+// parent.
+func (d *dumper) dumpPakArchive(a *archive) error {
+	// parse PAK archive.
+	dbg.Printf("extracting %q", a.pakPath)
+	archiveOffsets, err := pak.ParsePAKHeader(a.buf)
+	if err != nil {
+		return errors.Wrapf(err, "unable to extract %q", a.pakPath)
+	}
+	filesContents, err := pak.Split(a.buf)
+	if err != nil {
+		return errors.Wrapf(err, "unable to extract %q", a.pakPath)
+	}
+	pakName := pathutil.FileName(a.pakPath)
+	pakNameWithoutExt := pathutil.TrimExt(pakName)
+	dstDir := filepath.Join(a.dumpDir, pakNameWithoutExt)
+	// locate output paths of PAK subarchives (and files).
+	rawPaths := make([]string, len(filesContents))
+	dstPaths := make([]string, len(filesContents))
+	named := make([]bool, len(filesContents))
+	lastIndex := make(map[string]int)
 	for i, fileContents := range filesContents {
 		name := "archive"
 		ext := "pak"
@@ -102,46 +306,161 @@ func dumpPakArchive(pakPath, dumpDir string, listfile map[string]string) error {
 		}
 		dstName := fmt.Sprintf("%s_%04d.%s", name, i, ext)
 		dstPath := filepath.Join(dstDir, dstName)
+		rawPaths[i] = dstPath
 		if len(fileContents) == 0 {
 			//dbg.Println("skip empty file %q", dstPath)
 			continue
 		}
-		if len(listfile) > 0 {
-			if newPathName, ok := replaceName(dstPath, listfile); ok {
-				dstPath = newPathName
-			} else {
-				warn.Printf("file name not set for %q in listfile", dstPath)
+		newPathName, ok, err := d.replaceName(dstPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		named[i] = ok
+		if ok {
+			dstPath = newPathName
+		} else {
+			// use file extension of detected content type for unnamed files.
+			if typ := sniff.DetectBytes(fileContents); typ != sniff.Unknown {
+				dstPath = pathutil.TrimExt(dstPath) + typ.Ext
 			}
 		}
-		dbg.Printf("creating %q", dstPath)
-		if err := ioutil.WriteFile(dstPath, fileContents, 0o644); err != nil {
+		dstPaths[i] = dstPath
+		lastIndex[dstPath] = i
+	}
+	// when several files share the same output path, the last file takes the
+	// output path, and earlier files are output by index (e.g.
+	// "X/towner_9_dir~12.zel"), as named by pakfs.
+	for i, dstPath := range dstPaths {
+		if len(dstPath) > 0 && lastIndex[dstPath] != i {
+			dstPaths[i] = filepath.FromSlash(pakfs.ShadowedPath(filepath.ToSlash(dstPath), i))
+			dbg.Printf("file %d shadowed by file %d at %q; using %q", i, lastIndex[dstPath], dstPath, dstPaths[i])
+		}
+	}
+	// output PAK subarchives (and files).
+	createdDstDir := false
+	for i, dstPath := range dstPaths {
+		if err := d.ctx.Err(); err != nil {
 			return errors.WithStack(err)
 		}
-		if filepath.Ext(dstPath) == ".pak" {
-			subarchivePaths = append(subarchivePaths, dstPath)
+		fileContents := filesContents[i]
+		rawPath := rawPaths[i]
+		relRawPath, err := d.relPath(rawPath)
+		if err != nil {
+			return errors.WithStack(err)
 		}
-	}
-	// dump subarchives.
-	//dbg.Printf("--- [ dumping subarchives of %q ] ---", pakPath)
-	for _, subarchivePath := range subarchivePaths {
-		if err := dumpPakArchive(subarchivePath, dstDir, listfile); err != nil {
+		rawHash := sha1.Sum(fileContents)
+		f := &manifest.File{
+			Path:      relRawPath,
+			RawPath:   relRawPath,
+			Archive:   a.relPath,
+			Index:     i,
+			Offset:    int64(archiveOffsets[i]),
+			AbsOffset: a.absOffset + int64(archiveOffsets[i]),
+			Size:      int64(len(fileContents)),
+			SHA1:      hex.EncodeToString(rawHash[:]),
+		}
+		d.addToManifest(f)
+		if len(dstPath) == 0 {
+			// skip empty file.
+			continue
+		}
+		relDstPath, err := d.relPath(dstPath)
+		if err != nil {
 			return errors.WithStack(err)
 		}
-		// Only remove subarchive if present in listfile. If not present, it's
-		// probably a ZEL file that's not yet successfully decoded by zel_dump or
-		// a subdirectory that has not yet been named.
-		_, inListfile := reverseListfile[stripRootDumpDir(subarchivePath)]
-		if !keepSubarchive && inListfile {
-			// only keep extracted files of subarchive.
-			if err := os.Remove(subarchivePath); err != nil {
+		f.Path = relDstPath
+		isSubarchive := filepath.Ext(dstPath) == ".pak"
+		// check selection, by file path or default path (and directory path
+		// of subarchives).
+		candidates := []string{relDstPath, relRawPath}
+		if isSubarchive {
+			candidates = append(candidates, pathutil.TrimExt(relDstPath), pathutil.TrimExt(relRawPath))
+		}
+		selected := a.all || d.sel.match(candidates...)
+		descend := isSubarchive && (selected || d.sel.matchUnder(candidates[2:]...))
+		if !selected && !descend {
+			continue
+		}
+		if selected && d.lf.Len() > 0 && !named[i] {
+			warn.Printf("file name not set for %q in listfile", dstPath)
+		}
+		if selected && (!isSubarchive || d.keepSubarchive(relDstPath)) {
+			// create output directory.
+			if !createdDstDir {
+				if err := os.MkdirAll(dstDir, 0o755); err != nil {
+					return errors.WithStack(err)
+				}
+				createdDstDir = true
+			}
+			dbg.Printf("creating %q", dstPath)
+			if err := ioutil.WriteFile(dstPath, fileContents, 0o644); err != nil {
 				return errors.WithStack(err)
 			}
+			f.Extracted = true
+		}
+		if descend {
+			// dump subarchive.
+			f.Subarchive = true
+			sub := &archive{
+				pakPath:   dstPath,
+				relPath:   relDstPath,
+				absOffset: f.AbsOffset,
+				buf:       fileContents,
+				dumpDir:   dstDir,
+				all:       selected,
+			}
+			d.spawn(func() error {
+				return d.dumpPakArchive(sub)
+			})
 		}
 	}
 	return nil
 }
 
+// keepSubarchive reports whether to keep the given PAK subarchive after
+// extracting its files, based on the keep mode of the dumper.
+func (d *dumper) keepSubarchive(relPath string) bool {
+	switch d.keep {
+	case keepAll:
+		return true
+	case keepNone:
+		return false
+	default:
+		// Only keep subarchive if not present in listfile. If not present,
+		// it's probably a subdirectory that has not yet been named. Unnamed
+		// ZEL images are detected by their contents, and not extracted as
+		// subarchives.
+		_, inListfile := d.lf.RawPath(relPath)
+		return !inListfile
+	}
+}
+
+// addToManifest adds the given file to the manifest of the dumper. The file
+// may be updated until the manifest is written.
+func (d *dumper) addToManifest(f *manifest.File) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.manifest.Files = append(d.manifest.Files, f)
+}
+
+// writeManifest writes the manifest of dumped files to the output directory.
+func (d *dumper) writeManifest() error {
+	if err := os.MkdirAll(d.outDir, 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+	manifestPath := filepath.Join(d.outDir, manifestName)
+	dbg.Printf("creating %q", manifestPath)
+	if err := d.manifest.WriteFile(manifestPath); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 // isArchive reports whether the given contents is a PAK archive.
+//
+// Note: ZEL images are reported as PAK archives. The default file names of the
+// listfile are based on isArchive and isSound, while unnamed files are named
+// by their detected content type.
 func isArchive(buf []byte) bool {
 	_, err := pak.ParsePAKHeader(buf)
 	return err == nil
@@ -155,41 +474,220 @@ func isSound(buf []byte) bool {
 	return string(buf[0:4]) == "RIFF"
 }
 
-// parseListfile parses the given listfile.
-//
-// Example listfile:
-//
-//	{
-//		"X/archive_0000.pak": "X/core.pak",
-//		"X/core/file_0002.bin": "X/core/core.pal",
-//		"X/core/file_0003.bin": "X/core/palette.bmp"
-//	}
-func parseListfile(listfilePath string) (map[string]string, error) {
-	listfile := make(map[string]string)
-	if len(listfilePath) == 0 {
-		return listfile, nil
+// replaceName replaces the given path by a corresponding new path if a
+// replacement was specified in the listfile. New paths are located within the
+// root output directory.
+func (d *dumper) replaceName(dumpPath string) (string, bool, error) {
+	relPath, err := d.relPath(dumpPath)
+	if err != nil {
+		return "", false, errors.WithStack(err)
+	}
+	newPath, ok := d.lf.Name(relPath)
+	if !ok {
+		return dumpPath, false, nil
 	}
-	if err := jsonutil.ParseFile(listfilePath, &listfile); err != nil {
-		return nil, errors.WithStack(err)
+	localPath := filepath.FromSlash(newPath)
+	if !filepath.IsLocal(localPath) {
+		return "", false, errors.Errorf("invalid listfile path %q of %q; expected relative path within output directory", newPath, relPath)
 	}
-	return listfile, nil
+	return filepath.Join(d.outDir, localPath), true, nil
 }
 
-// replaceName replaces the given path by a corresponding new path if a
-// replacement was specified in the given listfile.
-func replaceName(path string, listfile map[string]string) (string, bool) {
-	if newPath, ok := listfile[stripRootDumpDir(path)]; ok {
-		return filepath.Join(rootDumpDir, newPath), true
+// relPath returns the slash-separated path of the given path relative to the
+// root output directory (e.g. "X/core/core.pal"), as used by listfiles.
+func (d *dumper) relPath(dumpPath string) (string, error) {
+	relPath, err := filepath.Rel(d.outDir, dumpPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !filepath.IsLocal(relPath) {
+		return "", errors.Errorf("invalid path %q; expected path within output directory %q", dumpPath, d.outDir)
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// exportPakFiles exports the files of the given PAK archives to a single zip
+// or tar archive, writing to standard output if outPath is "-".
+func exportPakFiles(pakPaths []string, outPath string, format export.Format, lf *listfile.Listfile, sel selection) (err error) {
+	w := io.Writer(os.Stdout)
+	if outPath != "-" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer func() {
+			if e := f.Close(); err == nil {
+				err = errors.WithStack(e)
+			}
+		}()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	ew, err := export.NewWriter(bw, format)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, pakPath := range pakPaths {
+		dbg.Printf("exporting %q", pakPath)
+		fsys, err := pakfs.Open(pakPath, lf)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = ew.AddFS(fsys, sel.filter())
+		fsys.Close()
+		if err != nil {
+			return errors.Wrapf(err, "unable to export %q", pakPath)
+		}
+	}
+	if err := ew.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := bw.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// stringsFlag is a string flag which may be specified several times.
+type stringsFlag []string
+
+// String returns the string representation of the flag values.
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set appends the given flag value.
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// selection is a set of glob patterns selecting files to extract, relative to
+// the root output directory. Patterns are matched against slash-separated paths
+// one path segment at a time, as by path.Match, with the addition that a "**"
+// segment matches zero or more path segments.
+type selection [][]string
+
+// newSelection returns a selection of the given glob patterns.
+func newSelection(patterns []string) (selection, error) {
+	var sel selection
+	for _, pattern := range patterns {
+		segs := strings.Split(path.Clean(filepath.ToSlash(pattern)), "/")
+		for _, seg := range segs {
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, errors.Wrapf(err, "invalid glob pattern %q", pattern)
+			}
+		}
+		sel = append(sel, segs)
+	}
+	return sel, nil
+}
+
+// isEmpty reports whether the selection is empty, thus selecting every file.
+func (sel selection) isEmpty() bool {
+	return len(sel) == 0
+}
+
+// match reports whether any of the given slash-separated paths is matched by a
+// pattern of the selection.
+func (sel selection) match(paths ...string) bool {
+	for _, p := range paths {
+		segs := strings.Split(p, "/")
+		for _, pattern := range sel {
+			if matchSegs(pattern, segs) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchUnder reports whether files located under any of the given
+// slash-separated directories may be matched by a pattern of the selection.
+func (sel selection) matchUnder(dirs ...string) bool {
+	for _, dir := range dirs {
+		segs := strings.Split(dir, "/")
+		for _, pattern := range sel {
+			if matchPrefixSegs(pattern, segs) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filter returns a filter of the files and directories of a pakfs file system,
+// matching files as selected when dumping, or nil if the selection is empty.
+func (sel selection) filter() func(name string, d fs.DirEntry) bool {
+	if sel.isEmpty() {
+		return nil
+	}
+	// directories of subarchives selected as a whole.
+	selected := make(map[string]bool)
+	return func(name string, d fs.DirEntry) bool {
+		if selected[path.Dir(name)] {
+			selected[name] = d.IsDir()
+			return true
+		}
+		info, err := d.Info()
+		if err != nil {
+			return false
+		}
+		e, ok := info.Sys().(*pakfs.Entry)
+		if !ok || e.Index == -1 {
+			// always descend into top-level archive.
+			return true
+		}
+		if !d.IsDir() {
+			return sel.match(e.Path, e.RawPath)
+		}
+		// check selection, by subarchive path or default path, and directory
+		// path of subarchive.
+		dirs := []string{e.Dir, pathutil.TrimExt(e.RawPath)}
+		if sel.match(append([]string{e.Path, e.RawPath}, dirs...)...) {
+			selected[name] = true
+			return true
+		}
+		return sel.matchUnder(dirs...)
 	}
-	return path, false
 }
 
-// stripRootDumpDir strips the root dump directory ("_dump_") from the prefix of
-// the given path.
-func stripRootDumpDir(path string) string {
-	parts := strings.Split(path, string(filepath.Separator))
-	if parts[0] != rootDumpDir {
-		panic(fmt.Errorf("invalid path root; expected root dump dir %q, got %q", rootDumpDir, parts[0]))
+// matchSegs reports whether the given path segments are matched by the
+// specified pattern segments.
+func matchSegs(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSegs(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+		return false
+	}
+	return matchSegs(pattern[1:], segs[1:])
+}
+
+// matchPrefixSegs reports whether paths located under the directory of the
+// given path segments may be matched by the specified pattern segments.
+func matchPrefixSegs(pattern, dirSegs []string) bool {
+	for i, seg := range dirSegs {
+		if i >= len(pattern) {
+			return false
+		}
+		if pattern[i] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[i], seg); !ok {
+			return false
+		}
 	}
-	return filepath.Join(parts[1:]...)
+	return len(pattern) > len(dirSegs)
 }
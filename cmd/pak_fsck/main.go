@@ -0,0 +1,136 @@
+// pak_fsck checks the integrity of PAK archives, recursively checking
+// subarchives, and reports all problems found with their offsets.
+//
+// The exit status is 1 if any archive is damaged.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mewkiz/pkg/term"
+	"github.com/mewspring/pak/archive/pak"
+	"github.com/mewspring/pak/archive/pak/sniff"
+	"github.com/pkg/errors"
+)
+
+// dbg is a logger with the "pak_fsck:" prefix which logs debug messages to
+// standard error.
+var dbg = log.New(os.Stderr, term.MagentaBold("pak_fsck:")+" ", 0)
+
+func usage() {
+	const usage = "Usage: pak_fsck [OPTIONS]... FILE.pak..."
+	fmt.Fprintln(os.Stderr, usage)
+	flag.PrintDefaults()
+}
+
+func main() {
+	// parse command line arguments.
+	var (
+		jsonOutput bool
+		noWarn     bool
+	)
+	flag.BoolVar(&jsonOutput, "json", false, "output diagnostics in JSON format")
+	flag.BoolVar(&noWarn, "no-warn", false, "omit warnings (e.g. zero-length files)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	var reports []*Report
+	damaged := false
+	for _, pakPath := range flag.Args() {
+		report, err := checkPakFile(pakPath, noWarn)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if report.Damaged {
+			damaged = true
+		}
+		reports = append(reports, report)
+	}
+	if jsonOutput {
+		if err := writeJSON(os.Stdout, reports); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	} else {
+		writeText(os.Stdout, reports)
+	}
+	if damaged {
+		os.Exit(1)
+	}
+}
+
+// Report is the integrity report of a PAK archive.
+type Report struct {
+	// Path of the PAK archive.
+	Path string `json:"path"`
+	// Reports whether any error was found.
+	Damaged bool `json:"damaged"`
+	// Problems found, sorted by offset.
+	Diagnostics []*pak.Diagnostic `json:"diagnostics"`
+}
+
+// checkPakFile checks the integrity of the given PAK archive file.
+func checkPakFile(pakPath string, noWarn bool) (*Report, error) {
+	dbg.Printf("checking %q", pakPath)
+	f, err := os.Open(pakPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c := &pak.Checker{
+		// ZEL images share the layout of the PAK header; don't check their
+		// frames as files.
+		Descend: func(r io.ReaderAt, size int64) bool {
+			return sniff.Detect(r, size).Name != "zel"
+		},
+	}
+	diags, err := c.Check(f, fi.Size())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to check %q", pakPath)
+	}
+	report := &Report{Path: pakPath}
+	for _, diag := range diags {
+		if diag.Severity == pak.SeverityError {
+			report.Damaged = true
+		} else if noWarn {
+			continue
+		}
+		report.Diagnostics = append(report.Diagnostics, diag)
+	}
+	return report, nil
+}
+
+// writeJSON writes the given reports in JSON format.
+func writeJSON(w io.Writer, reports []*Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(reports); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeText writes the given reports in human-readable format.
+func writeText(w io.Writer, reports []*Report) {
+	for _, report := range reports {
+		status := "ok"
+		if report.Damaged {
+			status = "damaged"
+		}
+		fmt.Fprintf(w, "%s: %s\n", report.Path, status)
+		for _, diag := range report.Diagnostics {
+			fmt.Fprintf(w, "\t%s\n", diag)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// pak_ident identifies the known game build of PAK archives, by the SHA1 hashes
+// of the files they contain.
+//
+// By default, pak_ident detects whether zel_patch has been applied to the
+// archives; the shipped database records the files touched by zel_patch only,
+// before ("unpatched") and after ("patched") applying zel_patch. To identify
+// game releases, generate a database of the releases using -gen and specify it
+// using -db.
+//
+// The exit status is 1 if an archive matches no known build exactly (or, with
+// -require, is not the required build).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mewkiz/pkg/term"
+	"github.com/mewspring/pak/archive/pak"
+	"github.com/mewspring/pak/archive/pak/builds"
+	"github.com/mewspring/pak/archive/pak/listfile"
+	"github.com/mewspring/pak/archive/pak/pakfs"
+	"github.com/pkg/errors"
+)
+
+// dbg is a logger with the "pak_ident:" prefix which logs debug messages to
+// standard error.
+var dbg = log.New(os.Stderr, term.MagentaBold("pak_ident:")+" ", 0)
+
+func usage() {
+	const usage = "Usage: pak_ident [OPTIONS]... FILE.pak..."
+	fmt.Fprintln(os.Stderr, usage)
+	flag.PrintDefaults()
+}
+
+func main() {
+	// parse command line arguments.
+	var (
+		listfilePath string
+		dbPath       string
+		jsonOutput   bool
+		require      string
+		genName      string
+		pakName      string
+	)
+	flag.StringVar(&listfilePath, "listfile", "", "listfile path (JSON format)")
+	flag.StringVar(&dbPath, "db", "", "database of known builds (JSON format; default: zel_patch states shipped with archive/pak/builds)")
+	flag.BoolVar(&jsonOutput, "json", false, "output result in JSON format")
+	flag.StringVar(&require, "require", "", "require archives to be identified as the given build")
+	flag.StringVar(&genName, "gen", "", "output database entry of build with the given name, recording all files of the archive")
+	flag.StringVar(&pakName, "name", "", "archive name used for listfile lookup (default: file name of each archive)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 || (len(genName) > 0 && flag.NArg() != 1) {
+		flag.Usage()
+		os.Exit(1)
+	}
+	lf, err := listfile.ParseFile(listfilePath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if len(genName) > 0 {
+		if err := genBuild(os.Stdout, flag.Arg(0), pakName, genName, lf); err != nil {
+			log.Fatalf("%+v", err)
+		}
+		return
+	}
+	db, err := parseDB(dbPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	ok := true
+	var reports []*Report
+	for _, pakPath := range flag.Args() {
+		report, err := identify(pakPath, pakName, db, lf)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		if len(require) > 0 {
+			if !report.Is(require) {
+				ok = false
+			}
+		} else if report.Verdict == "unknown" {
+			ok = false
+		}
+		reports = append(reports, report)
+	}
+	if jsonOutput {
+		if err := writeJSON(os.Stdout, reports); err != nil {
+			log.Fatalf("%+v", err)
+		}
+	} else {
+		writeText(os.Stdout, reports)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// Report is the build identification of a PAK archive.
+type Report struct {
+	// Path of the PAK archive.
+	Path string `json:"path"`
+	// Build verdict (see builds.Result.Verdict).
+	Verdict string `json:"verdict"`
+	*builds.Result
+}
+
+// parseDB parses the given database of known builds, or returns the shipped
+// database of zel_patch states if dbPath is empty.
+func parseDB(dbPath string) (*builds.DB, error) {
+	if len(dbPath) == 0 {
+		return builds.ZelPatch()
+	}
+	return builds.ParseFile(dbPath)
+}
+
+// fingerprint returns the files of the given PAK archive with their hashes,
+// using pakName for listfile lookup (or the file name of the archive if empty).
+func fingerprint(pakPath, pakName string, lf *listfile.Listfile) ([]*builds.File, error) {
+	dbg.Printf("hashing %q", pakPath)
+	rc, err := pak.OpenReader(pakPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rc.Close()
+	if len(pakName) == 0 {
+		pakName = filepath.Base(pakPath)
+	}
+	fsys := pakfs.New(rc.Reader, pakName, lf)
+	files, err := builds.Fingerprint(fsys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to hash files of %q", pakPath)
+	}
+	return files, nil
+}
+
+// identify identifies the build of the given PAK archive.
+func identify(pakPath, pakName string, db *builds.DB, lf *listfile.Listfile) (*Report, error) {
+	files, err := fingerprint(pakPath, pakName, lf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	result := db.Identify(files)
+	report := &Report{
+		Path:    pakPath,
+		Verdict: result.Verdict(),
+		Result:  result,
+	}
+	return report, nil
+}
+
+// genBuild writes a database entry of the build with the given name, recording
+// all files of the given PAK archive.
+func genBuild(w io.Writer, pakPath, pakName, name string, lf *listfile.Listfile) error {
+	files, err := fingerprint(pakPath, pakName, lf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	db := &builds.DB{
+		Builds: []*builds.Build{builds.NewBuild(name, files)},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(db); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeJSON writes the given reports in JSON format.
+func writeJSON(w io.Writer, reports []*Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(reports); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeText writes the given reports in human-readable format.
+func writeText(w io.Writer, reports []*Report) {
+	for _, report := range reports {
+		fmt.Fprintf(w, "%s: %s\n", report.Path, report.Verdict)
+		for _, m := range report.Builds {
+			fmt.Fprintf(w, "\t%-24s %5d matched, %5d mismatched, %5d missing\n", m.Name, m.Matched, m.Mismatched, m.Missing)
+		}
+		if len(report.Unknown) > 0 {
+			fmt.Fprintf(w, "\tfiles matching no known build (%d):\n", len(report.Unknown))
+			for _, f := range report.Unknown {
+				fmt.Fprintf(w, "\t\t%s (%s) %s\n", f.Path, f.RawPath, f.SHA1)
+			}
+		}
+		fmt.Fprintf(w, "\tfiles not recorded by any known build: %d\n", len(report.Unrecorded))
+	}
+}
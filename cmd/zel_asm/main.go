@@ -0,0 +1,91 @@
+// zel_asm assembles ZEL images from textual RLE command listings, as written by
+// zel_disasm.
+//
+// The frame offsets of the ZEL header are recomputed, so frames may be edited
+// per command rather than by splicing bytes at absolute offsets; e.g. to add a
+// missing command of a corrupt frame.
+//
+//	frame 221 64x64
+//		...
+//		skip 12
+//		pixels 1B 1B ...
+//		skip.eol 25    ; added
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/mewkiz/pkg/pathutil"
+	"github.com/mewkiz/pkg/term"
+	"github.com/mewspring/pak/image/zel"
+	"github.com/pkg/errors"
+)
+
+var (
+	// dbg is a logger with the "zel_asm:" prefix which logs debug messages to
+	// standard error.
+	dbg = log.New(os.Stderr, term.MagentaBold("zel_asm:")+" ", 0)
+	// warn is a logger with the "zel_asm:" prefix which logs warning messages to
+	// standard error.
+	warn = log.New(os.Stderr, term.RedBold("zel_asm:")+" ", log.Lshortfile)
+)
+
+func usage() {
+	const usage = "Usage: zel_asm [OPTIONS]... FILE.zasm"
+	fmt.Fprintln(os.Stderr, usage)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Assembles the ZEL image of the RLE command listing FILE.zasm (e.g. as written by zel_disasm).")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+func main() {
+	// parse command line arguments.
+	var output string
+	flag.StringVar(&output, "o", "", "output ZEL image (default: FILE.zel)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	listingPath := flag.Arg(0)
+	if len(output) == 0 {
+		output = pathutil.TrimExt(listingPath) + ".zel"
+	}
+	if output == listingPath {
+		log.Fatalf("output ZEL image %q same as listing; use -o to specify output", output)
+	}
+	// assemble ZEL image.
+	if err := assembleZelImage(listingPath, output); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// assembleZelImage assembles the ZEL image of the given listing, and writes it
+// to the specified output path.
+func assembleZelImage(listingPath, output string) error {
+	f, err := os.Open(listingPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	buf, err := zel.Assemble(f)
+	if err != nil {
+		return errors.Wrapf(err, "unable to assemble %q", listingPath)
+	}
+	if err := zel.Validate(buf); err != nil {
+		// print warning but continue to write ZEL image, as it may be used to
+		// reproduce corrupt frames.
+		warn.Printf("invalid ZEL image assembled from %q; %v", listingPath, err)
+	}
+	dbg.Printf("creating %q", output)
+	if err := ioutil.WriteFile(output, buf, 0o644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
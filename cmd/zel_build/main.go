@@ -0,0 +1,238 @@
+// zel_build builds a ZEL image from a directory of PNG frames laid out as
+// dumped by zel_dump (e.g. "_dump_/X/cursors/hand/frame_0000.png").
+//
+// Opaque pixels are mapped to the nearest colour of the palette, with a warning
+// for pixels not matching a palette colour exactly, and fully transparent
+// pixels are stored as transparent. The palette indices of paletted PNG images
+// are kept for pixels whose colour matches the palette colour of the same
+// index (e.g. as dumped by zel_dump -paletted).
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/mewkiz/pkg/imgutil"
+	"github.com/mewkiz/pkg/term"
+	"github.com/mewspring/pak/image/zel"
+	"github.com/pkg/errors"
+)
+
+var (
+	// dbg is a logger with the "zel_build:" prefix which logs debug messages to
+	// standard error.
+	dbg = log.New(os.Stderr, term.MagentaBold("zel_build:")+" ", 0)
+	// warn is a logger with the "zel_build:" prefix which logs warning messages
+	// to standard error.
+	warn = log.New(os.Stderr, term.RedBold("zel_build:")+" ", log.Lshortfile)
+)
+
+func usage() {
+	const usage = "Usage: zel_build [OPTIONS]... DIR"
+	fmt.Fprintln(os.Stderr, usage)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Builds the ZEL image of DIR (e.g. _dump_/X/cursors/hand) from its frame_NNNN.png files.")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+func main() {
+	// parse command line arguments.
+	var (
+		palPath string
+		output  string
+		shadow  bool
+		strict  bool
+	)
+	flag.StringVar(&palPath, "pal", "", "palette path (256 RGBA colours)")
+	flag.StringVar(&output, "o", "", "output ZEL image (default: DIR.zel)")
+	flag.BoolVar(&shadow, "shadow", false, "encode as tileset shadows image (default: inferred from path, e.g. X/tilesets/tileset_1_shadows)")
+	flag.BoolVar(&strict, "strict", false, "fail if PNG images use colours not present in the palette")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	frameDir := filepath.Clean(flag.Arg(0))
+	if len(output) == 0 {
+		output = frameDir + ".zel"
+	}
+	// parse palette.
+	pal, err := zel.ParsePal(palPath)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	// build ZEL image.
+	b := &builder{
+		pal:    pal,
+		shadow: shadow || zel.IsShadowPath(output),
+		strict: strict,
+	}
+	if err := b.buildZelImage(frameDir, output); err != nil {
+		log.Fatalf("%+v", err)
+	}
+}
+
+// builder builds ZEL images from PNG frames.
+type builder struct {
+	// Palette of ZEL image.
+	pal color.Palette
+	// Encode as tileset shadows image.
+	shadow bool
+	// Fail on colours not present in the palette.
+	strict bool
+}
+
+// frameNameRegexp matches the file names of PNG frames (e.g. "frame_0003.png").
+var frameNameRegexp = regexp.MustCompile(`^frame_([0-9]+)\.png$`)
+
+// buildZelImage builds the ZEL image of the PNG frames in the given directory,
+// and writes it to the specified output path.
+func (b *builder) buildZelImage(frameDir, output string) error {
+	framePaths, err := locateFrames(frameDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var frames []image.Image
+	for i, framePath := range framePaths {
+		img, err := imgutil.ReadFile(framePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		frame, err := b.quantize(img, framePath)
+		if err != nil {
+			return errors.Wrapf(err, "unable to convert frame %d (%q)", i, framePath)
+		}
+		frames = append(frames, frame)
+	}
+	opts := &zel.Options{
+		Palette: b.pal,
+		Shadow:  b.shadow,
+	}
+	buf := &bytes.Buffer{}
+	if err := zel.EncodeAll(buf, frames, opts); err != nil {
+		return errors.WithStack(err)
+	}
+	dbg.Printf("creating %q", output)
+	if err := ioutil.WriteFile(output, buf.Bytes(), 0o644); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// locateFrames returns the paths of the PNG frames in the given directory,
+// sorted by frame index. Frame indices must be contiguous from 0.
+func locateFrames(frameDir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(frameDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	framePaths := make(map[int]string)
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		subs := frameNameRegexp.FindStringSubmatch(fi.Name())
+		if subs == nil {
+			continue
+		}
+		index, err := strconv.Atoi(subs[1])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if prev, ok := framePaths[index]; ok {
+			return nil, errors.Errorf("duplicate frame %d (%q and %q)", index, filepath.Base(prev), fi.Name())
+		}
+		framePaths[index] = filepath.Join(frameDir, fi.Name())
+	}
+	if len(framePaths) == 0 {
+		return nil, errors.Errorf("no frame_NNNN.png files in %q", frameDir)
+	}
+	var indices []int
+	for index := range framePaths {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	var paths []string
+	for i, index := range indices {
+		if index != i {
+			return nil, errors.Errorf("missing frame %d in %q", i, frameDir)
+		}
+		paths = append(paths, framePaths[index])
+	}
+	return paths, nil
+}
+
+// quantize converts the given image, read from the specified path, to a
+// paletted frame using colours of the palette. Fully transparent pixels are
+// masked as transparent, so frames may use every palette index.
+func (b *builder) quantize(img image.Image, framePath string) (*zel.MaskedPaletted, error) {
+	bounds := img.Bounds()
+	// exact palette indices by colour.
+	exact := make(map[color.NRGBA]int)
+	for i := len(b.pal) - 1; i >= 0; i-- {
+		exact[color.NRGBAModel.Convert(b.pal[i]).(color.NRGBA)] = i
+	}
+	src, isPaletted := img.(*image.Paletted)
+	dst := &zel.MaskedPaletted{
+		Paletted: image.NewPaletted(bounds, b.pal),
+		Mask:     image.NewAlpha(bounds),
+	}
+	// inexact records the number of pixels not matching a palette colour, and
+	// the first such pixel.
+	var inexact struct {
+		n     int
+		x, y  int
+		c     color.NRGBA
+		index int
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				// transparent pixel.
+				continue
+			}
+			index, ok := -1, false
+			if isPaletted {
+				// keep palette index of paletted image if matching.
+				i := int(src.ColorIndexAt(x, y))
+				if i < len(b.pal) && color.NRGBAModel.Convert(b.pal[i]) == c {
+					index, ok = i, true
+				}
+			}
+			if !ok {
+				index, ok = exact[c]
+			}
+			if !ok {
+				index = b.pal.Index(c)
+				if inexact.n == 0 {
+					inexact.x, inexact.y, inexact.c, inexact.index = x, y, c, index
+				}
+				inexact.n++
+			}
+			dst.SetColorIndex(x, y, uint8(index))
+			dst.Mask.SetAlpha(x, y, color.Alpha{A: 0xFF})
+		}
+	}
+	if inexact.n > 0 {
+		c := inexact.c
+		msg := fmt.Sprintf("%d pixels not matching palette colour (e.g. #%02X%02X%02X%02X at (%d, %d), nearest palette index %d)", inexact.n, c.R, c.G, c.B, c.A, inexact.x, inexact.y, inexact.index)
+		if b.strict {
+			return nil, errors.New(msg)
+		}
+		warn.Printf("%q: %s", framePath, msg)
+	}
+	return dst, nil
+}
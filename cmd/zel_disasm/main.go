@@ -0,0 +1,130 @@
+// zel_disasm disassembles the RLE command streams of ZEL images, for debugging
+// of corrupt frames.
+//
+// Each command is listed with its offset within the ZEL image, raw command
+// value and position within the frame; and lines whose width does not match
+// the frame width are reported. The remaining contents of frames which fail to
+// disassemble (e.g. truncated pixel runs) are listed as data, and the
+// disassembly continues with the next frame. The listing may be edited and
+// assembled back into a ZEL image using zel_asm.
+//
+// Example listing:
+//
+//	frame 0 64x2 ; offset 0x00000008 (31 bytes)
+//		skip 12           ; 0x0000000C  0x000C  x=0    y=0
+//		pixels 1B 1B 0E   ; 0x0000000E  0x1003  x=12   y=0
+//		skip.eol 25       ; 0x00000013  0x8019  x=15   y=0
+//		; problem: line width 40 does not match frame width 64
+//		...
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/mewkiz/pkg/term"
+	"github.com/mewspring/pak/image/zel"
+	"github.com/pkg/errors"
+)
+
+var (
+	// dbg is a logger with the "zel_disasm:" prefix which logs debug messages to
+	// standard error.
+	dbg = log.New(os.Stderr, term.MagentaBold("zel_disasm:")+" ", 0)
+	// warn is a logger with the "zel_disasm:" prefix which logs warning messages
+	// to standard error.
+	warn = log.New(os.Stderr, term.RedBold("zel_disasm:")+" ", log.Lshortfile)
+)
+
+func usage() {
+	const usage = "Usage: zel_disasm [OPTIONS]... FILE.zel"
+	fmt.Fprintln(os.Stderr, usage)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Disassembles the RLE command streams of FILE.zel (exit status 1 if problems are found).")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+func main() {
+	// parse command line arguments.
+	var (
+		output string
+		shadow bool
+		frame  int
+	)
+	flag.StringVar(&output, "o", "", "output listing (default: standard output)")
+	flag.BoolVar(&shadow, "shadow", false, "disassemble as tileset shadows image (default: inferred from path, e.g. X/tilesets/tileset_1_shadows.zel)")
+	flag.IntVar(&frame, "frame", -1, "only disassemble the given frame (assembled by zel_asm as a single frame image)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	zelPath := flag.Arg(0)
+	shadow = shadow || zel.IsShadowPath(zelPath)
+	// disassemble ZEL image.
+	w := os.Stdout
+	if len(output) > 0 {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Fatalf("%+v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	nproblems, err := disasmZelImage(w, zelPath, shadow, frame)
+	if err != nil {
+		log.Fatalf("%+v", err)
+	}
+	if nproblems > 0 {
+		warn.Printf("%d problems found in %q", nproblems, zelPath)
+		os.Exit(1)
+	}
+}
+
+// disasmZelImage writes the listing of the given ZEL image to w, and returns
+// the number of problems found. Only the given frame is listed if frame is not
+// -1.
+func disasmZelImage(w io.Writer, zelPath string, shadow bool, frame int) (int, error) {
+	buf, err := ioutil.ReadFile(zelPath)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	s := zel.NewScanner(buf, shadow)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	fmt.Fprintf(bw, "; %s\n", zelPath)
+	if shadow {
+		fmt.Fprintln(bw, "; tileset shadows image")
+	}
+	nproblems := 0
+	for s.Scan() {
+		cmd := s.Command()
+		if frame != -1 && cmd.Frame != frame {
+			continue
+		}
+		switch cmd.Op {
+		case zel.OpFrame:
+			fmt.Fprintf(bw, "\n%s ; offset 0x%08X (%d bytes)\n", cmd, cmd.Offset, cmd.Size)
+		case zel.OpData:
+			fmt.Fprintf(bw, "\t%-17s ; 0x%08X\n", cmd, cmd.Offset)
+		default:
+			fmt.Fprintf(bw, "\t%-17s ; 0x%08X  0x%04X  x=%-4d y=%d\n", cmd, cmd.Offset, cmd.Raw, cmd.X, cmd.Y)
+		}
+		for _, problem := range cmd.Problems {
+			fmt.Fprintf(bw, "\t; problem: %s\n", problem)
+			nproblems++
+		}
+	}
+	if err := s.Err(); err != nil {
+		fmt.Fprintf(bw, "\t; error: %v\n", err)
+		return nproblems, errors.Wrapf(err, "unable to disassemble %q", zelPath)
+	}
+	return nproblems, nil
+}
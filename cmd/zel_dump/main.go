@@ -8,7 +8,8 @@ package main
 import (
 	"flag"
 	"fmt"
-	"image/color"
+	"image"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -37,31 +38,71 @@ func usage() {
 
 func main() {
 	// parse command line arguments.
-	var palPath string
+	var (
+		palPath          string
+		shadow           bool
+		partial          bool
+		opts             zel.Options
+		transparentIndex uint
+	)
 	flag.StringVar(&palPath, "pal", "", "palette path (256 RGBA colours)")
+	flag.BoolVar(&shadow, "shadow", false, "decode as tileset shadows images (default: inferred from path, e.g. X/tilesets/tileset_1_shadows.zel)")
+	flag.BoolVar(&partial, "partial", false, "output partially decoded frames of frames failing to decode (e.g. partial_frame_0003.png)")
+	flag.BoolVar(&opts.Paletted, "paletted", false, "output paletted PNG images, keeping the original palette indices of pixels")
+	flag.UintVar(&transparentIndex, "transparent", zel.DefaultTransparentIndex, "palette index of transparent pixels of paletted PNG images")
 	flag.Usage = usage
 	flag.Parse()
 	if flag.NArg() == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if transparentIndex > 0xFF {
+		log.Fatalf("invalid -transparent palette index %d; expected <= 255", transparentIndex)
+	}
+	ti := uint8(transparentIndex)
+	opts.TransparentIndex = &ti
 	// parse palette.
 	pal, err := zel.ParsePal(palPath)
 	if err != nil {
 		log.Fatalf("%+v", err)
 	}
+	opts.Palette = pal
 	// dump ZEL image frames.
 	for _, zelPath := range flag.Args() {
-		if err := dumpZelImage(zelPath, pal); err != nil {
+		if err := dumpZelImage(zelPath, opts, shadow, partial); err != nil {
 			log.Fatalf("%+v", err)
 		}
 	}
 }
 
-// dumpZelImage dumps the given ZEL image to the specified output directory.
-func dumpZelImage(zelPath string, pal color.Palette) error {
-	imgs, err := zel.DecodeAll(zelPath, pal)
+// dumpZelImage dumps the given ZEL image to the specified output directory,
+// using the given decoding options. The image is decoded as a tileset shadows
+// image if shadow is set, or if inferred from its path. Partially decoded frames
+// of frames failing to decode are output if partial is set.
+func dumpZelImage(zelPath string, opts zel.Options, shadow, partial bool) error {
+	buf, err := ioutil.ReadFile(zelPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dstDir := pathutil.TrimExt(zelPath)
+	opts.Shadow = shadow || zel.IsShadowPath(zelPath)
+	if partial {
+		opts.OnFrameError = func(index int, img image.Image, err error) {
+			if err := os.MkdirAll(dstDir, 0o755); err != nil {
+				warn.Printf("unable to create output directory; %+v", err)
+				return
+			}
+			pngName := fmt.Sprintf("partial_frame_%04d.png", index)
+			pngPath := filepath.Join(dstDir, pngName)
+			dbg.Printf("creating %q", pngPath)
+			if err := imgutil.WriteFile(pngPath, img); err != nil {
+				warn.Printf("unable to write partial frame; %+v", err)
+			}
+		}
+	}
+	imgs, err := zel.DecodeBytes(buf, &opts)
 	if err != nil {
+		err = errors.Wrapf(err, "unable to decode %q", zelPath)
 		if len(imgs) > 0 {
 			// print warning but continue to dump partial image results.
 			warn.Printf("decode error for %q; %+v", zelPath, err)
@@ -70,7 +111,6 @@ func dumpZelImage(zelPath string, pal color.Palette) error {
 		}
 	}
 	// create output directory.
-	dstDir := pathutil.TrimExt(zelPath)
 	if err := os.MkdirAll(dstDir, 0o755); err != nil {
 		return errors.WithStack(err)
 	}
@@ -78,7 +118,8 @@ func dumpZelImage(zelPath string, pal color.Palette) error {
 	for i, img := range imgs {
 		pngName := fmt.Sprintf("frame_%04d.png", i)
 		pngPath := filepath.Join(dstDir, pngName)
-		dbg.Printf("creating %q", pngPath)
+		bounds := img.Bounds()
+		dbg.Printf("creating %q (%dx%d)", pngPath, bounds.Dx(), bounds.Dy())
 		if err := imgutil.WriteFile(pngPath, img); err != nil {
 			return errors.WithStack(err)
 		}
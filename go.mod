@@ -4,13 +4,8 @@ go 1.21
 
 require (
 	github.com/Noofbiz/tmx v0.2.0
-	github.com/kr/pretty v0.3.1
 	github.com/mewkiz/pkg v0.0.0-20230226050401-4010bf0fec14
 	github.com/pkg/errors v0.9.1
 )
 
-require (
-	github.com/kr/text v0.2.0 // indirect
-	github.com/rogpeppe/go-internal v1.9.0 // indirect
-	golang.org/x/image v0.5.0 // indirect
-)
+require golang.org/x/image v0.5.0 // indirect
@@ -0,0 +1,235 @@
+package zel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Assemble assembles the ZEL image of the given assembly listing, as written by
+// the String method of Command (e.g. "skip.eol 25"), one command per line. The
+// frame offsets of the ZEL header are computed from the assembled frames.
+//
+// Comments start with a semicolon and extend to the end of the line. Each frame
+// starts with a frame directive holding the frame index and dimensions, or
+// "empty" for empty frames; frames must be listed in order. The first frame
+// index may be non-zero (e.g. as listed by zel_disasm -frame), in which case
+// the assembled image holds the listed frames only, starting at frame 0.
+//
+// Empty frames have no frame header, and hold no commands except for data
+// commands (e.g. the contents of a truncated frame header).
+//
+//	frame 0 64x2
+//		skip 12
+//		pixels 1B 1B 0E
+//		skip.eol 49
+//		lines 1
+//		end
+//	frame 1 empty
+//
+// Commands are assembled as listed, without validation of line widths; see
+// Validate and Scanner. Raw command values are computed from the operation,
+// count and end of line flag of each command. Non-canonical commands are listed
+// by raw value instead, followed by pixel data if any, and assembled as is.
+//
+//	raw 0x5003
+//	raw 0x3002 1B 0E
+func Assemble(r io.Reader) ([]byte, error) {
+	var (
+		framesContents [][]byte
+		// contents of current frame.
+		frame *bytes.Buffer
+		// current frame is empty.
+		empty bool
+		// index of first frame.
+		first int
+	)
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		// syntaxErr returns a SyntaxError at the current line.
+		syntaxErr := func(format string, args ...interface{}) error {
+			e := &SyntaxError{
+				Line:   lineNum,
+				Reason: fmt.Sprintf(format, args...),
+			}
+			return errors.WithStack(e)
+		}
+		line := s.Text()
+		if pos := strings.IndexByte(line, ';'); pos != -1 {
+			// strip comment.
+			line = line[:pos]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		mnemonic, args := fields[0], fields[1:]
+		if mnemonic == "raw" {
+			// command of raw value.
+			switch {
+			case frame == nil:
+				return nil, syntaxErr("%q command outside of frame; expected frame directive", mnemonic)
+			case empty:
+				return nil, syntaxErr("%q command in empty frame; expected data command", mnemonic)
+			case len(args) == 0:
+				return nil, syntaxErr("invalid %q command; expected raw value (e.g. 0x5003)", mnemonic)
+			}
+			raw, err := strconv.ParseUint(args[0], 0, 16)
+			if err != nil {
+				return nil, syntaxErr("invalid raw value %q; expected 16-bit value (e.g. 0x5003)", args[0])
+			}
+			data, err := parseHexBytes(args[1:])
+			if err != nil {
+				return nil, syntaxErr("invalid %q command; %v", mnemonic, err)
+			}
+			putUint16(frame, uint16(raw))
+			frame.Write(data)
+			framesContents[len(framesContents)-1] = frame.Bytes()
+			continue
+		}
+		eol := false
+		if strings.HasSuffix(mnemonic, ".eol") {
+			mnemonic = strings.TrimSuffix(mnemonic, ".eol")
+			eol = true
+		}
+		op, ok := opFromMnemonic[mnemonic]
+		if !ok {
+			return nil, syntaxErr("unknown mnemonic %q", fields[0])
+		}
+		if eol && !(op == OpLines || op == OpPixels || op == OpShadow || op == OpSkip) {
+			return nil, syntaxErr("end of line flag not supported by %q", op)
+		}
+		if op == OpFrame {
+			// start of frame.
+			if len(args) != 2 {
+				return nil, syntaxErr("invalid frame directive; expected frame index and dimensions (e.g. \"frame 0 64x32\" or \"frame 1 empty\")")
+			}
+			index, err := strconv.Atoi(args[0])
+			if err == nil && len(framesContents) == 0 && index >= 0 {
+				first = index
+			}
+			if err != nil || index != first+len(framesContents) {
+				return nil, syntaxErr("invalid frame index %q; expected %d", args[0], first+len(framesContents))
+			}
+			frame = &bytes.Buffer{}
+			framesContents = append(framesContents, nil)
+			empty = args[1] == "empty"
+			if empty {
+				continue
+			}
+			width, height, err := parseDimensions(args[1])
+			if err != nil {
+				return nil, syntaxErr("%v", err)
+			}
+			putUint16(frame, uint16(width))
+			putUint16(frame, uint16(height))
+		} else {
+			// command.
+			switch {
+			case frame == nil:
+				return nil, syntaxErr("%q command outside of frame; expected frame directive", op)
+			case empty && op != OpData:
+				return nil, syntaxErr("%q command in empty frame; expected data command", op)
+			}
+			var count int
+			var data []byte
+			switch op {
+			case OpLines, OpShadow, OpSkip:
+				if len(args) != 1 {
+					return nil, syntaxErr("invalid %q command; expected count", op)
+				}
+				n, err := strconv.ParseUint(args[0], 10, 12)
+				if err != nil {
+					return nil, syntaxErr("invalid count %q of %q command; expected 0 <= count <= %d", args[0], op, 0xFFF)
+				}
+				count = int(n)
+			case OpPixels, OpData:
+				var err error
+				if data, err = parseHexBytes(args); err != nil {
+					return nil, syntaxErr("invalid %q command; %v", op, err)
+				}
+				if op == OpPixels {
+					count = len(data)
+					if count > 0xFFF {
+						return nil, syntaxErr("too many pixels of %q command; expected <= %d, got %d", op, 0xFFF, count)
+					}
+				}
+			case OpEnd:
+				if len(args) != 0 {
+					return nil, syntaxErr("invalid %q command; unexpected arguments", op)
+				}
+			}
+			if op != OpData {
+				putUint16(frame, canonicalRaw(op, count, eol))
+			}
+			frame.Write(data)
+		}
+		framesContents[len(framesContents)-1] = frame.Bytes()
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(framesContents) == 0 {
+		return nil, errors.WithStack(ErrNoFrames)
+	}
+	buf := &bytes.Buffer{}
+	if err := writeZel(buf, framesContents); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// opFromMnemonic maps from mnemonic to RLE command operation.
+var opFromMnemonic = map[string]Op{
+	"frame":  OpFrame,
+	"lines":  OpLines,
+	"pixels": OpPixels,
+	"shadow": OpShadow,
+	"skip":   OpSkip,
+	"end":    OpEnd,
+	"data":   OpData,
+}
+
+// parseDimensions parses the given frame dimensions (e.g. "64x32").
+func parseDimensions(s string) (width, height int, err error) {
+	parts := strings.Split(s, "x")
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid frame dimensions %q; expected WIDTHxHEIGHT (e.g. 64x32)", s)
+	}
+	w, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, 0, errors.Errorf("invalid frame width %q; expected 0 <= width <= %d", parts[0], 0xFFFF)
+	}
+	h, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return 0, 0, errors.Errorf("invalid frame height %q; expected 0 <= height <= %d", parts[1], 0xFFFF)
+	}
+	return int(w), int(h), nil
+}
+
+// parseHexBytes parses the given hexadecimal bytes (e.g. "1B" and "0E").
+func parseHexBytes(args []string) ([]byte, error) {
+	var data []byte
+	for _, arg := range args {
+		b, err := strconv.ParseUint(arg, 16, 8)
+		if err != nil {
+			return nil, errors.Errorf("invalid byte %q; expected hexadecimal byte (e.g. 1B)", arg)
+		}
+		data = append(data, uint8(b))
+	}
+	return data, nil
+}
+
+// putUint16 writes the given 16-bit value to buf.
+func putUint16(buf *bytes.Buffer, v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	buf.Write(b[:])
+}
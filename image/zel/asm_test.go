@@ -0,0 +1,154 @@
+package zel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"strings"
+	"testing"
+)
+
+// TestAssembleRoundTrip checks that disassembling and assembling a ZEL image
+// reproduces the exact contents of the image, including non-canonical
+// commands, unprocessed frame contents and empty frames.
+func TestAssembleRoundTrip(t *testing.T) {
+	// encode frame of opaque and transparent pixel runs.
+	pal := FramePalette(DefaultPalette, 0xFF)
+	img := image.NewPaletted(image.Rect(0, 0, 8, 4), pal)
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	for x := 2; x < 6; x++ {
+		img.SetColorIndex(x, 1, uint8(0x10+x))
+	}
+	img.SetColorIndex(7, 2, 0x1B)
+	frameContents, err := EncodeFrame(img, nil)
+	if err != nil {
+		t.Fatalf("unable to encode frame; %v", err)
+	}
+	// make the first skip and pixels commands non-canonical by setting the
+	// unused 0x2000 bit.
+	patched := map[Op]bool{}
+	s := NewScanner(zelImage(t, frameContents), false)
+	// offset of frame contents within the ZEL image of a single frame.
+	const frameOffset = 8
+	for s.Scan() {
+		cmd := s.Command()
+		if (cmd.Op == OpSkip || cmd.Op == OpPixels) && !patched[cmd.Op] {
+			pos := int(cmd.Offset) - frameOffset
+			binary.LittleEndian.PutUint16(frameContents[pos:], cmd.Raw|0x2000)
+			patched[cmd.Op] = true
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("unable to disassemble frame; %v", err)
+	}
+	if !patched[OpSkip] || !patched[OpPixels] {
+		t.Fatalf("missing skip or pixels command in frame; patched %v", patched)
+	}
+	// append unprocessed frame contents after the end of the frame.
+	frameContents = append(frameContents, 0xDE, 0xAD)
+	want := zelImage(t, frameContents, nil)
+
+	// disassemble.
+	s = NewScanner(want, false)
+	listing := &strings.Builder{}
+	for s.Scan() {
+		listing.WriteString(s.Command().String())
+		listing.WriteString("\n")
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("unable to disassemble image; %v", err)
+	}
+	if n := strings.Count(listing.String(), "raw 0x"); n != 2 {
+		t.Errorf("expected 2 raw commands in listing, got %d:\n%s", n, listing)
+	}
+
+	// assemble.
+	got, err := Assemble(strings.NewReader(listing.String()))
+	if err != nil {
+		t.Fatalf("unable to assemble listing; %v\n%s", err, listing)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("assembled image differs from disassembled image;\n\tgot  % X\n\twant % X\nlisting:\n%s", got, want, listing)
+	}
+}
+
+// zelImage returns the contents of the ZEL image of the given frames.
+func zelImage(t *testing.T, framesContents ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	if err := writeZel(buf, framesContents); err != nil {
+		t.Fatalf("unable to write ZEL image; %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestAssembleRaw checks the assembly of commands listed by raw value.
+func TestAssembleRaw(t *testing.T) {
+	const listing = `
+frame 0 2x1
+	raw 0x5000      ; lines with unused 0x1000 bit
+	raw 0x3002 1B 0E
+	end
+`
+	got, err := Assemble(strings.NewReader(listing))
+	if err != nil {
+		t.Fatalf("unable to assemble listing; %v", err)
+	}
+	want := zelImage(t, []byte{0x02, 0x00, 0x01, 0x00, 0x00, 0x50, 0x02, 0x30, 0x1B, 0x0E, 0x00, 0x00})
+	if !bytes.Equal(got, want) {
+		t.Errorf("assembled image mismatch;\n\tgot  % X\n\twant % X", got, want)
+	}
+	if _, err := Assemble(strings.NewReader("frame 0 1x1\n\traw 0x10000\n")); err == nil {
+		t.Errorf("expected error for raw value exceeding 16 bits")
+	}
+}
+
+// TestAssembleDamagedRoundTrip checks that frames following a frame which
+// fails to disassemble are listed, and that the listing of a damaged ZEL image
+// assembles back into the exact contents of the image.
+func TestAssembleDamagedRoundTrip(t *testing.T) {
+	want := zelImage(t,
+		// truncated pixel run.
+		[]byte{0x02, 0x00, 0x01, 0x00, 0x03, 0x90, 0x1B, 0x0E},
+		// truncated frame header.
+		[]byte{0x02, 0x00},
+		// valid frame.
+		[]byte{0x02, 0x00, 0x01, 0x00, 0x02, 0x90, 0x1B, 0x0E, 0x00, 0x00},
+		// unterminated RLE command stream.
+		[]byte{0x02, 0x00, 0x01, 0x00, 0x02, 0x80},
+	)
+	s := NewScanner(want, false)
+	listing := &strings.Builder{}
+	frames, nproblems := 0, 0
+	for s.Scan() {
+		cmd := s.Command()
+		if cmd.Op == OpFrame {
+			frames++
+		}
+		nproblems += len(cmd.Problems)
+		listing.WriteString(cmd.String())
+		listing.WriteString("\n")
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("unable to disassemble image; %v", err)
+	}
+	if frames != 4 || nproblems != 3 {
+		t.Errorf("expected 4 frames and 3 problems, got %d frames and %d problems:\n%s", frames, nproblems, listing)
+	}
+	got, err := Assemble(strings.NewReader(listing.String()))
+	if err != nil {
+		t.Fatalf("unable to assemble listing; %v\n%s", err, listing)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("assembled image differs from disassembled image;\n\tgot  % X\n\twant % X\nlisting:\n%s", got, want, listing)
+	}
+	// assemble listing of single frame (e.g. zel_disasm -frame 2).
+	got, err = Assemble(strings.NewReader("frame 2 2x1\n\tpixels.eol 1B 0E\n\tend\n"))
+	if err != nil {
+		t.Fatalf("unable to assemble listing of frame 2; %v", err)
+	}
+	if want := zelImage(t, []byte{0x02, 0x00, 0x01, 0x00, 0x02, 0x90, 0x1B, 0x0E, 0x00, 0x00}); !bytes.Equal(got, want) {
+		t.Errorf("assembled image of frame 2 mismatch;\n\tgot  % X\n\twant % X", got, want)
+	}
+}
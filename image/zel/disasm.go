@@ -0,0 +1,391 @@
+package zel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Op is the operation of an RLE command.
+type Op uint8
+
+// RLE command operations.
+const (
+	// Start of frame (pseudo-command); width and height of the frame header, or
+	// empty frame.
+	OpFrame Op = iota + 1
+	// Transparent lines (0x4000).
+	OpLines
+	// Regular pixels (0x1000), followed by pixel data.
+	OpPixels
+	// Constant pixels of tileset shadows (0x1000), without pixel data.
+	OpShadow
+	// Transparent pixels.
+	OpSkip
+	// End of frame (0x0000).
+	OpEnd
+	// Unprocessed frame contents after the end of the frame (pseudo-command).
+	OpData
+)
+
+// String returns the mnemonic of the operation.
+func (op Op) String() string {
+	m := map[Op]string{
+		OpFrame:  "frame",
+		OpLines:  "lines",
+		OpPixels: "pixels",
+		OpShadow: "shadow",
+		OpSkip:   "skip",
+		OpEnd:    "end",
+		OpData:   "data",
+	}
+	if s, ok := m[op]; ok {
+		return s
+	}
+	return fmt.Sprintf("Op(%d)", uint8(op))
+}
+
+// Command is an RLE command of a ZEL frame.
+type Command struct {
+	// Index of the frame within the ZEL image.
+	Frame int
+	// Offset in bytes of the command within the ZEL image.
+	Offset int64
+	// Operation of the command.
+	Op Op
+	// Raw command value; zero for pseudo-commands.
+	Raw uint16
+	// Number of lines (OpLines) or pixels (OpPixels, OpShadow and OpSkip).
+	Count int
+	// End of line flag (0x8000).
+	EOL bool
+	// Pixel data of regular pixels (OpPixels), or unprocessed frame contents
+	// (OpData).
+	Data []byte
+	// Position of the command within the frame; running x position within the
+	// line (not wrapped at the frame width), and line number.
+	X, Y int
+	// Frame dimensions (OpFrame); zero for empty frames.
+	Width, Height int
+	// Size in bytes of the frame contents (OpFrame); frames too short to hold a
+	// frame header are listed as empty, followed by their contents as data.
+	Size int
+	// Problems found with the command (e.g. line width not matching the frame
+	// width); the command is valid if empty.
+	Problems []string
+}
+
+// String returns the assembly listing of the command (e.g. "skip.eol 25").
+// Commands whose raw value differs from the canonical raw value of their
+// operation, count and end of line flag are listed by raw value, followed by
+// pixel data if any (e.g. "raw 0x5003" or "raw 0x3002 1B 0E"), to be assembled
+// as is.
+func (cmd *Command) String() string {
+	if cmd.Op != OpFrame && cmd.Op != OpData && cmd.Raw != canonicalRaw(cmd.Op, cmd.Count, cmd.EOL) {
+		if len(cmd.Data) > 0 {
+			return fmt.Sprintf("raw 0x%04X %s", cmd.Raw, hexBytes(cmd.Data))
+		}
+		return fmt.Sprintf("raw 0x%04X", cmd.Raw)
+	}
+	mnemonic := cmd.Op.String()
+	if cmd.EOL {
+		mnemonic += ".eol"
+	}
+	switch cmd.Op {
+	case OpFrame:
+		if cmd.Size < frameHdrSize {
+			// empty frame, or truncated frame header listed as data.
+			return fmt.Sprintf("%s %d empty", mnemonic, cmd.Frame)
+		}
+		return fmt.Sprintf("%s %d %dx%d", mnemonic, cmd.Frame, cmd.Width, cmd.Height)
+	case OpLines, OpShadow, OpSkip:
+		return fmt.Sprintf("%s %d", mnemonic, cmd.Count)
+	case OpPixels, OpData:
+		if len(cmd.Data) == 0 {
+			return mnemonic
+		}
+		return fmt.Sprintf("%s %s", mnemonic, hexBytes(cmd.Data))
+	default:
+		return mnemonic
+	}
+}
+
+// hexBytes returns the space-separated hexadecimal representation of the given
+// bytes (e.g. "1B 0E").
+func hexBytes(data []byte) string {
+	var ss []string
+	for _, b := range data {
+		ss = append(ss, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(ss, " ")
+}
+
+// Scanner iterates over the RLE commands of the frames of a ZEL image. Each
+// frame starts with an OpFrame pseudo-command, followed by the commands of the
+// frame, if non-empty.
+//
+// Frames which fail to disassemble (e.g. truncated pixel runs) do not stop the
+// scan; the remaining contents of the frame are listed as an OpData command
+// with a problem, and the scan continues with the next frame.
+//
+// Example usage:
+//
+//	s := zel.NewScanner(buf, false)
+//	for s.Scan() {
+//		cmd := s.Command()
+//		fmt.Println(cmd)
+//	}
+//	if err := s.Err(); err != nil {
+//		return err
+//	}
+type Scanner struct {
+	// ZEL image contents.
+	buf []byte
+	// Decode as tileset shadows image.
+	shadow bool
+	// Frame offsets of ZEL header.
+	frameOffsets []uint32
+	// Index of current frame.
+	frame int
+	// Position of next command within the ZEL image.
+	pos int
+	// End offset of current frame.
+	end int
+	// Dimensions of current frame.
+	width, height int
+	// Position of next command within frame.
+	x, y int
+	// Frame terminated by end command.
+	done bool
+	// Current command.
+	cmd *Command
+	// Pending OpData command of unprocessed frame contents.
+	pending *Command
+	// Error of ZEL header.
+	err error
+}
+
+// NewScanner returns a new scanner over the RLE commands of the given ZEL
+// image contents. Pixel runs contain no pixel data if shadow is set, as used by
+// tileset shadows images.
+func NewScanner(buf []byte, shadow bool) *Scanner {
+	s := &Scanner{
+		buf:    buf,
+		shadow: shadow,
+		frame:  -1,
+		done:   true,
+	}
+	frameOffsets, err := parseZelHeader(buf)
+	if err != nil {
+		s.err = errors.WithStack(err)
+	}
+	s.frameOffsets = frameOffsets
+	return s
+}
+
+// Scan advances the scanner to the next command, which is then available
+// through Command. It returns false when the scan stops, either by reaching the
+// end of the ZEL image or on error of the ZEL header.
+//
+// Invalid frame contents are reported as problems of commands and not as
+// errors; see Scanner.
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	if s.pending != nil {
+		s.cmd, s.pending = s.pending, nil
+		return true
+	}
+	if s.done {
+		// start of frame.
+		s.frame++
+		if s.frame >= len(s.frameOffsets)-1 {
+			return false
+		}
+		s.startFrame()
+		return true
+	}
+	s.cmd = s.next()
+	return true
+}
+
+// Command returns the current command.
+func (s *Scanner) Command() *Command {
+	return s.cmd
+}
+
+// Err returns the error of the ZEL header, if any; no frames are scanned if the
+// ZEL header is invalid.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// startFrame starts the scan of the current frame.
+func (s *Scanner) startFrame() {
+	frameStartOffset := s.frameOffsets[s.frame]
+	frameEndOffset := s.frameOffsets[s.frame+1]
+	s.cmd = &Command{
+		Frame:  s.frame,
+		Offset: int64(frameStartOffset),
+		Op:     OpFrame,
+		Size:   int(frameEndOffset - frameStartOffset),
+	}
+	s.x, s.y = 0, 0
+	s.pos = int(frameStartOffset)
+	s.end = int(frameEndOffset)
+	if frameStartOffset == frameEndOffset {
+		// empty frame.
+		s.done = true
+		return
+	}
+	frameContents := s.buf[frameStartOffset:frameEndOffset]
+	if len(frameContents) < frameHdrSize {
+		// list contents of truncated frame header as data.
+		s.pending = s.rest(s.pos, "too short frame header; expected >= %d bytes, got %d", frameHdrSize, len(frameContents))
+		return
+	}
+	s.width = int(binary.LittleEndian.Uint16(frameContents[0:2]))
+	s.height = int(binary.LittleEndian.Uint16(frameContents[2:4]))
+	s.cmd.Width, s.cmd.Height = s.width, s.height
+	if _, _, err := parseFrameHeader(frameContents, s.frame, int64(frameStartOffset)); err != nil {
+		// invalid frame dimensions; the commands of the frame are still
+		// listed.
+		var e *FrameError
+		if errors.As(err, &e) {
+			s.cmd.Problems = append(s.cmd.Problems, e.Reason)
+		} else {
+			s.cmd.Problems = append(s.cmd.Problems, err.Error())
+		}
+	}
+	s.pos += frameHdrSize
+	s.done = false
+}
+
+// rest returns an OpData command of the remaining contents of the current
+// frame from the given offset, with the given problem, and ends the scan of the
+// frame.
+func (s *Scanner) rest(offset int, format string, args ...interface{}) *Command {
+	cmd := &Command{
+		Frame:    s.frame,
+		Offset:   int64(offset),
+		Op:       OpData,
+		Data:     s.buf[offset:s.end:s.end],
+		X:        s.x,
+		Y:        s.y,
+		Problems: []string{fmt.Sprintf(format, args...)},
+	}
+	s.pos = s.end
+	s.done = true
+	return cmd
+}
+
+// next returns the next command of the current frame. The remaining contents
+// of the frame are returned as an OpData command if the frame fails to
+// disassemble.
+func (s *Scanner) next() *Command {
+	cmd := &Command{
+		Frame:  s.frame,
+		Offset: int64(s.pos),
+		X:      s.x,
+		Y:      s.y,
+	}
+	problem := func(format string, args ...interface{}) {
+		cmd.Problems = append(cmd.Problems, fmt.Sprintf(format, args...))
+	}
+	if s.pos+2 > s.end {
+		if s.pos < s.end {
+			return s.rest(s.pos, "truncated RLE command")
+		}
+		return s.rest(s.pos, "unterminated RLE command stream")
+	}
+	raw := binary.LittleEndian.Uint16(s.buf[s.pos:])
+	s.pos += 2
+	cmd.Raw = raw
+	cmd.Count = int(raw & 0xFFF)
+	cmd.EOL = raw&0x8000 != 0
+	switch {
+	case raw == 0:
+		cmd.Op = OpEnd
+		cmd.Count = 0
+		if s.x != 0 {
+			problem("end of frame at x=%d; expected x=0", s.x)
+		}
+		if s.pos < s.end {
+			// unprocessed frame contents.
+			data := &Command{
+				Frame:  s.frame,
+				Offset: int64(s.pos),
+				Op:     OpData,
+				Data:   s.buf[s.pos:s.end:s.end],
+			}
+			data.Problems = append(data.Problems, fmt.Sprintf("%d bytes after end of frame", len(data.Data)))
+			s.pending = data
+		}
+		s.done = true
+		return cmd
+	case raw&0x4000 != 0:
+		cmd.Op = OpLines
+		if s.x != 0 {
+			problem("transparent lines at x=%d; expected x=0", s.x)
+		}
+		if cmd.Count > s.height {
+			problem("ySkip (%d) exceeds frame height (%d)", cmd.Count, s.height)
+		}
+		s.y += cmd.Count
+	case raw&0x1000 != 0:
+		cmd.Op = OpPixels
+		if s.shadow {
+			cmd.Op = OpShadow
+		} else {
+			if s.pos+cmd.Count > s.end {
+				return s.rest(int(cmd.Offset), "pixel data of %d pixels exceeds frame contents", cmd.Count)
+			}
+			cmd.Data = s.buf[s.pos : s.pos+cmd.Count : s.pos+cmd.Count]
+			s.pos += cmd.Count
+		}
+		s.x += cmd.Count
+	default:
+		cmd.Op = OpSkip
+		s.x += cmd.Count
+	}
+	if canonical := canonicalRaw(cmd.Op, cmd.Count, cmd.EOL); raw != canonical {
+		problem("non-canonical command 0x%04X; expected 0x%04X", raw, canonical)
+	}
+	if s.y > s.height {
+		problem("line %d exceeds frame height (%d)", s.y, s.height)
+	}
+	if cmd.EOL {
+		// end of line.
+		if cmd.Op != OpLines {
+			if s.x != s.width {
+				problem("line width %d does not match frame width %d", s.x, s.width)
+			}
+			s.y++
+		}
+		s.x = 0
+	} else if s.x > s.width {
+		problem("line width %d exceeds frame width %d", s.x, s.width)
+	}
+	return cmd
+}
+
+// canonicalRaw returns the raw command value of the given operation, count and
+// end of line flag.
+func canonicalRaw(op Op, count int, eol bool) uint16 {
+	raw := uint16(count)
+	switch op {
+	case OpEnd:
+		return 0
+	case OpLines:
+		raw |= 0x4000
+	case OpPixels, OpShadow:
+		raw |= 0x1000
+	}
+	if eol {
+		raw |= 0x8000
+	}
+	return raw
+}
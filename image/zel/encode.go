@@ -0,0 +1,256 @@
+package zel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Encode writes the given image to w as a single frame ZEL image, using the
+// given options (may be nil); see EncodeFrame.
+func Encode(w io.Writer, img image.Image, opts *Options) error {
+	return EncodeAll(w, []image.Image{img}, opts)
+}
+
+// EncodeAll writes the given sequential frames to w as a ZEL image, using the
+// given options (may be nil); see EncodeFrame.
+func EncodeAll(w io.Writer, imgs []image.Image, opts *Options) error {
+	if len(imgs) == 0 {
+		return errors.WithStack(ErrNoFrames)
+	}
+	// encode frames.
+	var framesContents [][]byte
+	for i, img := range imgs {
+		frameContents, err := EncodeFrame(img, opts)
+		if err != nil {
+			return errors.Wrapf(err, "unable to encode frame %d", i)
+		}
+		framesContents = append(framesContents, frameContents)
+	}
+	if err := writeZel(w, framesContents); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// writeZel writes a ZEL image of the given frame contents to w, computing the
+// frame offsets of the ZEL header.
+func writeZel(w io.Writer, framesContents [][]byte) error {
+	// write ZEL header.
+	frameOffsets := make([]uint32, len(framesContents)+1)
+	frameOffsets[0] = uint32(len(frameOffsets) * 4)
+	for i, frameContents := range framesContents {
+		frameOffsets[i+1] = frameOffsets[i] + uint32(len(frameContents))
+	}
+	if err := binary.Write(w, binary.LittleEndian, frameOffsets); err != nil {
+		return errors.WithStack(err)
+	}
+	// write frames.
+	for _, frameContents := range framesContents {
+		if _, err := w.Write(frameContents); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// EncodeFrame returns the contents of the given ZEL frame, using the given
+// options (may be nil).
+//
+// The palette indices of *image.Paletted frames are stored as is, and pixels
+// whose palette colour is fully transparent (e.g. the reserved palette index of
+// FramePalette) are stored as transparent. The palette indices of
+// *MaskedPaletted frames are stored as is, and pixels are stored as transparent
+// as given by the mask, which allows frames to use every palette index. Fully
+// transparent pixels of other
+// frames are stored as transparent, and the remaining pixels as the index of
+// the nearest colour of opts.Palette (DefaultPalette if nil).
+//
+// Tileset shadows images are encoded if opts.Shadow is set, in which case
+// pixel runs contain no pixel data; any opaque pixel is decoded as the constant
+// palette index 8.
+//
+// A 1x1 fully transparent frame is encoded as an empty frame, as used by
+// DecodeBytes for empty frames.
+func EncodeFrame(img image.Image, opts *Options) ([]byte, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Palette == nil {
+		o.Palette = DefaultPalette
+	}
+	bounds := img.Bounds()
+	frameWidth, frameHeight := bounds.Dx(), bounds.Dy()
+	// sanity check.
+	switch {
+	case frameWidth == 0 || frameHeight == 0:
+		return nil, errors.Wrapf(ErrBadDimensions, "invalid frame dimensions %dx%d", frameWidth, frameHeight)
+	case frameWidth > maxFrameWidth || frameHeight > maxFrameHeight:
+		return nil, errors.Wrapf(ErrFrameTooLarge, "frame dimensions %dx%d exceed %dx%d", frameWidth, frameHeight, maxFrameWidth, maxFrameHeight)
+	}
+	palIndex := pixelIndexer(img, o.Palette)
+	if frameWidth == 1 && frameHeight == 1 {
+		if _, ok := palIndex(bounds.Min.X, bounds.Min.Y); !ok {
+			// empty frame.
+			return nil, nil
+		}
+	}
+	e := &frameEncoder{shadow: o.Shadow}
+	e.put(uint16(frameWidth))
+	e.put(uint16(frameHeight))
+	ySkip := 0
+	row := make([]int, frameWidth)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		// palette indices of row; -1 for transparent pixels.
+		opaque := false
+		for x := range row {
+			index, ok := palIndex(bounds.Min.X+x, y)
+			if !ok {
+				row[x] = -1
+				continue
+			}
+			row[x] = index
+			opaque = true
+		}
+		if !opaque {
+			// transparent line.
+			ySkip++
+			continue
+		}
+		e.ySkip(ySkip)
+		ySkip = 0
+		e.row(row)
+	}
+	e.ySkip(ySkip)
+	// terminate RLE command stream.
+	e.put(0)
+	return e.buf.Bytes(), nil
+}
+
+// pixelIndexer returns a function which returns the palette index of the pixel
+// at (x, y) of the given image, and reports whether the pixel is opaque.
+func pixelIndexer(img image.Image, pal color.Palette) func(x, y int) (int, bool) {
+	if m, ok := img.(*MaskedPaletted); ok {
+		return func(x, y int) (int, bool) {
+			if !m.Opaque(x, y) {
+				return 0, false
+			}
+			return int(m.Paletted.ColorIndexAt(x, y)), true
+		}
+	}
+	if p, ok := img.(*image.Paletted); ok {
+		// locate transparent palette indices.
+		var transparent [256]bool
+		for i, c := range p.Palette {
+			if i >= len(transparent) {
+				break
+			}
+			if _, _, _, a := c.RGBA(); a == 0 {
+				transparent[i] = true
+			}
+		}
+		return func(x, y int) (int, bool) {
+			index := p.ColorIndexAt(x, y)
+			if transparent[index] {
+				return 0, false
+			}
+			return int(index), true
+		}
+	}
+	// cache of palette indices by colour.
+	cache := make(map[color.Color]int)
+	return func(x, y int) (int, bool) {
+		c := img.At(x, y)
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return 0, false
+		}
+		index, ok := cache[c]
+		if !ok {
+			index = pal.Index(c)
+			cache[c] = index
+		}
+		return index, true
+	}
+}
+
+// MaskedPaletted is a paletted frame whose transparent pixels are given by a
+// mask instead of the palette; e.g. to encode frames using every palette index.
+type MaskedPaletted struct {
+	*image.Paletted
+	// Mask of transparent pixels; pixels of zero alpha are transparent. Every
+	// pixel is opaque if nil.
+	Mask *image.Alpha
+}
+
+// Opaque reports whether the pixel at (x, y) is opaque.
+func (m *MaskedPaletted) Opaque(x, y int) bool {
+	return m.Mask == nil || m.Mask.AlphaAt(x, y).A != 0
+}
+
+// At returns the colour of the pixel at (x, y); color.Transparent for
+// transparent pixels.
+func (m *MaskedPaletted) At(x, y int) color.Color {
+	if !m.Opaque(x, y) {
+		return color.Transparent
+	}
+	return m.Paletted.At(x, y)
+}
+
+// frameEncoder encodes the RLE command stream of a ZEL frame.
+type frameEncoder struct {
+	// Encoded frame contents.
+	buf bytes.Buffer
+	// Encode tileset shadows image; pixel runs contain no pixel data.
+	shadow bool
+}
+
+// put writes the given 16-bit value.
+func (e *frameEncoder) put(v uint16) {
+	putUint16(&e.buf, v)
+}
+
+// ySkip writes a command for n transparent lines, if n > 0.
+func (e *frameEncoder) ySkip(n int) {
+	for n > 0 {
+		m := min(n, 0xFFF)
+		e.put(0x4000 | uint16(m))
+		n -= m
+	}
+}
+
+// row writes the commands of the given row of palette indices, in which
+// transparent pixels are -1. The last command of the row is flagged as end of
+// line.
+func (e *frameEncoder) row(row []int) {
+	for x := 0; x < len(row); {
+		// locate end of run.
+		end := x + 1
+		transparent := row[x] == -1
+		for end < len(row) && (row[end] == -1) == transparent {
+			end++
+		}
+		n := end - x
+		var cmd uint16
+		if !transparent {
+			// regular pixels.
+			cmd = 0x1000
+		}
+		cmd |= uint16(n)
+		if end == len(row) {
+			// end of line.
+			cmd |= 0x8000
+		}
+		e.put(cmd)
+		if !transparent && !e.shadow {
+			for _, index := range row[x:end] {
+				e.buf.WriteByte(uint8(index))
+			}
+		}
+		x = end
+	}
+}
@@ -0,0 +1,106 @@
+package zel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestEncodeRoundTrip checks that encoding, decoding and re-encoding ZEL images
+// reproduces the exact contents of the encoded images.
+func TestEncodeRoundTrip(t *testing.T) {
+	pal := FramePalette(DefaultPalette, DefaultTransparentIndex)
+	// opaque frame.
+	opaque := image.NewPaletted(image.Rect(0, 0, 5, 3), pal)
+	for i := range opaque.Pix {
+		opaque.Pix[i] = uint8(0x10 + i)
+	}
+	// masked frame, with transparent lines at the top, middle and bottom, and
+	// transparent pixel runs at the start, middle and end of lines.
+	masked := &MaskedPaletted{
+		Paletted: image.NewPaletted(image.Rect(0, 0, 6, 6), pal),
+		Mask:     image.NewAlpha(image.Rect(0, 0, 6, 6)),
+	}
+	for i := range masked.Pix {
+		masked.Pix[i] = uint8(0x20 + i)
+	}
+	for _, p := range []image.Point{{0, 1}, {1, 1}, {4, 1}, {2, 3}, {3, 3}, {5, 3}} {
+		masked.Mask.SetAlpha(p.X, p.Y, color.Alpha{A: 0xFF})
+	}
+	// empty frame.
+	empty := image.NewPaletted(image.Rect(0, 0, 1, 1), pal)
+	empty.Pix[0] = DefaultTransparentIndex
+	imgs := []image.Image{opaque, masked, empty, opaque}
+	opts := &Options{Paletted: true}
+	checkEncodeRoundTrip(t, imgs, opts, 4)
+
+	// tileset shadows image.
+	shadow := image.NewPaletted(image.Rect(0, 0, 4, 3), pal)
+	for i := range shadow.Pix {
+		shadow.Pix[i] = DefaultTransparentIndex
+	}
+	shadow.SetColorIndex(1, 0, 8)
+	shadow.SetColorIndex(2, 0, 8)
+	shadow.SetColorIndex(3, 2, 8)
+	opts = &Options{Paletted: true, Shadow: true}
+	checkEncodeRoundTrip(t, []image.Image{shadow, empty}, opts, 2)
+}
+
+// checkEncodeRoundTrip checks that encoding the given frames, decoding the
+// encoded ZEL image into nframes frames and re-encoding the decoded frames
+// reproduces the encoded ZEL image.
+func checkEncodeRoundTrip(t *testing.T, imgs []image.Image, opts *Options, nframes int) {
+	t.Helper()
+	want := &bytes.Buffer{}
+	if err := EncodeAll(want, imgs, opts); err != nil {
+		t.Fatalf("unable to encode frames; %v", err)
+	}
+	if err := Validate(want.Bytes()); err != nil {
+		t.Fatalf("invalid encoded ZEL image; %v", err)
+	}
+	decoded, err := DecodeBytes(want.Bytes(), opts)
+	if err != nil {
+		t.Fatalf("unable to decode encoded ZEL image; %v", err)
+	}
+	if len(decoded) != nframes {
+		t.Fatalf("frame count mismatch; expected %d, got %d", nframes, len(decoded))
+	}
+	got := &bytes.Buffer{}
+	if err := EncodeAll(got, decoded, opts); err != nil {
+		t.Fatalf("unable to re-encode decoded frames; %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("re-encoded ZEL image differs from encoded image;\n\tgot  % X\n\twant % X", got.Bytes(), want.Bytes())
+	}
+}
+
+// TestFrameEncoderYSkip checks that runs of transparent lines exceeding the
+// maximum count of an RLE command (0xFFF) are split across several commands.
+//
+// The split is not reachable through EncodeFrame, as the maximum frame height
+// is less than 0xFFF lines.
+func TestFrameEncoderYSkip(t *testing.T) {
+	golden := []struct {
+		n    int
+		want []uint16
+	}{
+		{n: 0, want: nil},
+		{n: 1, want: []uint16{0x4001}},
+		{n: 0xFFF, want: []uint16{0x4FFF}},
+		{n: 0x1000, want: []uint16{0x4FFF, 0x4001}},
+		{n: 0x2000, want: []uint16{0x4FFF, 0x4FFF, 0x4002}},
+	}
+	for _, g := range golden {
+		e := &frameEncoder{}
+		e.ySkip(g.n)
+		var want bytes.Buffer
+		for _, v := range g.want {
+			binary.Write(&want, binary.LittleEndian, v)
+		}
+		if !bytes.Equal(e.buf.Bytes(), want.Bytes()) {
+			t.Errorf("ySkip(%d) mismatch; expected % X, got % X", g.n, want.Bytes(), e.buf.Bytes())
+		}
+	}
+}
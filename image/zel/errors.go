@@ -0,0 +1,81 @@
+package zel
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Underlying errors of HeaderError and FrameError, which may be tested using
+// errors.Is.
+var (
+	// ErrTruncated reports a ZEL image or frame too short to hold its contents.
+	ErrTruncated = errors.New("truncated ZEL image")
+	// ErrFrameTooLarge reports frame dimensions exceeding the maximum frame
+	// size.
+	ErrFrameTooLarge = errors.New("frame too large")
+	// ErrBadDimensions reports a frame of zero width or height.
+	ErrBadDimensions = errors.New("invalid frame dimensions")
+	// ErrBadCommand reports an RLE command exceeding the bounds of the frame.
+	ErrBadCommand = errors.New("invalid RLE command")
+	// ErrReservedIndex reports pixel data using the reserved palette index of
+	// transparent pixels, when decoding paletted frames.
+	ErrReservedIndex = errors.New("pixel uses reserved transparent palette index")
+	// ErrNoFrames reports a ZEL image without non-empty frames.
+	ErrNoFrames = errors.New("no non-empty frames in ZEL image")
+)
+
+// HeaderError reports an invalid ZEL header.
+type HeaderError struct {
+	// Offset in bytes of the invalid header field.
+	Offset int64
+	// Description of the problem.
+	Reason string
+	// Underlying error (e.g. ErrTruncated); may be nil.
+	Err error
+}
+
+// Error returns the error message.
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("invalid ZEL header at offset %d: %s", e.Offset, e.Reason)
+}
+
+// Unwrap returns the underlying error.
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+// FrameError reports an invalid frame of a ZEL image.
+type FrameError struct {
+	// Index of the frame within the ZEL image.
+	Index int
+	// Offset in bytes of the problem within the ZEL image; the offset of the
+	// RLE command for invalid commands, and of the frame otherwise.
+	Offset int64
+	// Description of the problem.
+	Reason string
+	// Underlying error (e.g. ErrFrameTooLarge); may be nil.
+	Err error
+}
+
+// Error returns the error message.
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("invalid frame %d at offset %d: %s", e.Index, e.Offset, e.Reason)
+}
+
+// Unwrap returns the underlying error.
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// SyntaxError reports an invalid line of a ZEL assembly listing.
+type SyntaxError struct {
+	// Line number (1-based).
+	Line int
+	// Description of the problem.
+	Reason string
+}
+
+// Error returns the error message.
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid ZEL listing at line %d: %s", e.Line, e.Reason)
+}
@@ -0,0 +1,172 @@
+package zel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPalette is the palette used to colour pixels when no palette is
+// specified; e.g. when decoding ZEL images through image.Decode (see package
+// zel/register). Set it to the game palette (see ParsePal) before decoding, as
+// ZEL images do not contain a palette.
+var DefaultPalette color.Palette = palette.Plan9
+
+// DecodeImage decodes the first non-empty frame of the ZEL image read from r,
+// using colours from DefaultPalette.
+//
+// DecodeImage and DecodeConfig are registered with image.RegisterFormat by
+// importing package zel/register for side effects. Registration is opt-in, as
+// ZEL images have no magic number.
+func DecodeImage(r io.Reader) (image.Image, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	frameOffsets, err := parseZelHeader(buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for i := 0; i < len(frameOffsets)-1; i++ {
+		frameStartOffset := frameOffsets[i]
+		frameEndOffset := frameOffsets[i+1]
+		if frameStartOffset == frameEndOffset {
+			// skip empty frame.
+			continue
+		}
+		frameContents := buf[frameStartOffset:frameEndOffset:frameEndOffset]
+		img, err := parseFrame(frameContents, i, int64(frameStartOffset), &Options{Palette: DefaultPalette})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return img, nil
+	}
+	return nil, errors.WithStack(&HeaderError{Offset: 0, Reason: "no non-empty frames", Err: ErrNoFrames})
+}
+
+// DecodeConfig returns the dimensions and colour model of the first non-empty
+// frame of the ZEL image read from r, without decoding its RLE command stream.
+// Only the ZEL header and frame header are read from r.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	// read ZEL header.
+	var rawHdrSize [4]byte
+	if _, err := io.ReadFull(r, rawHdrSize[:]); err != nil {
+		return image.Config{}, errors.WithStack(truncated(0, err))
+	}
+	zelHdrSize := int64(binary.LittleEndian.Uint32(rawHdrSize[:]))
+	if zelHdrSize < 8 || zelHdrSize%4 != 0 {
+		err := &HeaderError{
+			Offset: 0,
+			Reason: fmt.Sprintf("invalid header size; expected multiple of 4 and >= 8, got %d", zelHdrSize),
+		}
+		return image.Config{}, errors.WithStack(err)
+	}
+	// locate first non-empty frame; the frame offsets are read one at a time,
+	// as the size of the ZEL image is not known.
+	nframes := int(zelHdrSize/4) - 1
+	pos := int64(4)
+	frameEndOffset := uint32(zelHdrSize)
+	for i := 0; i < nframes; i++ {
+		frameStartOffset := frameEndOffset
+		if err := binary.Read(r, binary.LittleEndian, &frameEndOffset); err != nil {
+			return image.Config{}, errors.WithStack(truncated(pos, err))
+		}
+		pos += 4
+		if frameStartOffset > frameEndOffset {
+			return image.Config{}, errors.WithStack(invalidFrameOffset(i, frameStartOffset, frameEndOffset))
+		}
+		if frameStartOffset == frameEndOffset {
+			// skip empty frame.
+			continue
+		}
+		if int64(frameStartOffset) < zelHdrSize {
+			err := &FrameError{
+				Index:  i,
+				Offset: int64(frameStartOffset),
+				Reason: fmt.Sprintf("frame overlaps ZEL header of %d bytes", zelHdrSize),
+			}
+			return image.Config{}, errors.WithStack(err)
+		}
+		// skip remaining frame offsets.
+		if err := skip(r, int64(frameStartOffset)-pos); err != nil {
+			return image.Config{}, errors.WithStack(truncated(pos, err))
+		}
+		// read frame header.
+		frameHdr := make([]byte, frameHdrSize)
+		n, err := io.ReadFull(r, frameHdr)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return image.Config{}, errors.WithStack(err)
+		}
+		if frameSize := int(frameEndOffset - frameStartOffset); n > frameSize {
+			n = frameSize
+		}
+		width, height, err := parseFrameHeader(frameHdr[:n], i, int64(frameStartOffset))
+		if err != nil {
+			return image.Config{}, errors.WithStack(err)
+		}
+		cfg := image.Config{
+			ColorModel: color.RGBAModel,
+			Width:      width,
+			Height:     height,
+		}
+		return cfg, nil
+	}
+	return image.Config{}, errors.WithStack(&HeaderError{Offset: 0, Reason: "no non-empty frames", Err: ErrNoFrames})
+}
+
+// DecodeFrameConfigs returns the dimensions and colour model of the sequential
+// frames of the given ZEL image contents, without decoding their RLE command
+// streams. Empty frames are reported as 1x1, as decoded by DecodeBytes.
+func DecodeFrameConfigs(buf []byte) ([]image.Config, error) {
+	frameOffsets, err := parseZelHeader(buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var cfgs []image.Config
+	for i := 0; i < len(frameOffsets)-1; i++ {
+		frameStartOffset := frameOffsets[i]
+		frameEndOffset := frameOffsets[i+1]
+		frameContents := buf[frameStartOffset:frameEndOffset]
+		cfg := image.Config{
+			ColorModel: color.RGBAModel,
+			Width:      1,
+			Height:     1,
+		}
+		if len(frameContents) > 0 {
+			width, height, err := parseFrameHeader(frameContents, i, int64(frameStartOffset))
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			cfg.Width, cfg.Height = width, height
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
+
+// skip skips n bytes of r.
+func skip(r io.Reader, n int64) error {
+	if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// truncated returns a HeaderError for a ZEL image truncated at the given
+// offset, if err reports an unexpected end of file; and err otherwise.
+func truncated(offset int64, err error) error {
+	if cause := errors.Cause(err); cause != io.EOF && cause != io.ErrUnexpectedEOF {
+		return err
+	}
+	return &HeaderError{
+		Offset: offset,
+		Reason: "unexpected end of file",
+		Err:    ErrTruncated,
+	}
+}
@@ -1,7 +1,6 @@
 package zel
 
 import (
-	"fmt"
 	"image/color"
 	"image/color/palette"
 	"io/ioutil"
@@ -22,7 +21,7 @@ func ParsePal(palPath string) (color.Palette, error) {
 	}
 	const ncolors = 256
 	if len(buf) != ncolors*4 {
-		panic(fmt.Errorf("invalid palette length; expected 256*4, got %d", len(buf)))
+		return nil, errors.Errorf("invalid palette length of %q; expected 256*4, got %d", palPath, len(buf))
 	}
 	pal := make(color.Palette, ncolors)
 	for i := range pal {
@@ -0,0 +1,22 @@
+// Package register registers the ZEL image format with image.Decode, when
+// imported for side effects.
+//
+//	import _ "github.com/mewspring/pak/image/zel/register"
+//
+// ZEL images have no magic number; the header starts with the size of the frame
+// offsets table, which is less than 64 KiB. Registration is therefore opt-in,
+// as any input whose bytes 2-3 are zero is claimed as a ZEL image (e.g. PAK
+// archives, which share the header format of ZEL images). The frame offsets
+// table is further validated by the decoder; its size must be 4*(nframes+1),
+// and its offsets monotonic and within the bounds of the file.
+package register
+
+import (
+	"image"
+
+	"github.com/mewspring/pak/image/zel"
+)
+
+func init() {
+	image.RegisterFormat("zel", "??\x00\x00", zel.DecodeImage, zel.DecodeConfig)
+}
@@ -0,0 +1,229 @@
+package zel
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Maximum frame dimensions.
+//
+// NOTE: 650 is a valid width of `archive_0012/archive_0005/frame_0000.png`.
+// NOTE: 640 is a valid height of `archive_0012/archive_0001/frame_0165.png`.
+// NOTE: 1037 is a valid width of `archive_0012/archive_0040/frame_0034.png`.
+const (
+	maxFrameWidth  = 1280
+	maxFrameHeight = 1280
+)
+
+// Validate validates the structure of the given ZEL image contents; the frame
+// offsets must be monotonic and end at the end of the file, and each non-empty
+// frame must have plausible dimensions and an RLE command stream which
+// terminates within the bounds of the frame.
+//
+// Validate is used to distinguish ZEL images from PAK archives, which share the
+// layout of the file header.
+func Validate(buf []byte) error {
+	frameOffsets, err := parseZelHeader(buf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	nframes := len(frameOffsets) - 1
+	nonEmpty := 0
+	for i := 0; i < nframes; i++ {
+		frameStartOffset := frameOffsets[i]
+		frameEndOffset := frameOffsets[i+1]
+		// frame offsets have been validated by parseZelHeader.
+		frameContents := buf[frameStartOffset:frameEndOffset]
+		if len(frameContents) == 0 {
+			// skip empty frame.
+			continue
+		}
+		nonEmpty++
+		// the constant pixel runs of tileset shadows contain no pixel data, so
+		// try both variants of the RLE command stream.
+		if err := validateFrame(frameContents, i, int64(frameStartOffset), false); err != nil {
+			if validateFrame(frameContents, i, int64(frameStartOffset), true) != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	if nonEmpty == 0 {
+		return errors.WithStack(&HeaderError{Offset: 0, Reason: "no non-empty frames", Err: ErrNoFrames})
+	}
+	return nil
+}
+
+// parseZelHeader parses the ZEL header of the given ZEL image contents, and
+// returns the frame offsets. The frame offsets are validated to be monotonic
+// and within the bounds of buf, so frame contents may be sliced from buf.
+func parseZelHeader(buf []byte) ([]uint32, error) {
+	if len(buf) < 4 {
+		err := &HeaderError{
+			Offset: 0,
+			Reason: fmt.Sprintf("too short; expected >= 4 bytes, got %d", len(buf)),
+			Err:    ErrTruncated,
+		}
+		return nil, errors.WithStack(err)
+	}
+	zelHdrSize := int(binary.LittleEndian.Uint32(buf[0:4]))
+	// the header size is 4*(nframes+1); the frame offsets followed by the end
+	// offset of the last frame.
+	if zelHdrSize < 8 || zelHdrSize%4 != 0 || zelHdrSize > len(buf) {
+		err := &HeaderError{
+			Offset: 0,
+			Reason: fmt.Sprintf("invalid header size; expected multiple of 4, >= 8 and <= %d, got %d", len(buf), zelHdrSize),
+		}
+		return nil, errors.WithStack(err)
+	}
+	frameOffsetsLen := zelHdrSize / 4
+	frameOffsets := make([]uint32, frameOffsetsLen)
+	for i := range frameOffsets {
+		frameOffsets[i] = binary.LittleEndian.Uint32(buf[i*4:])
+	}
+	nframes := len(frameOffsets) - 1
+	if len(buf) != int(frameOffsets[nframes]) {
+		err := &HeaderError{
+			Offset: int64(nframes) * 4,
+			Reason: fmt.Sprintf("mismatch between frameOffsets[%d]=%d and file size %d", nframes, frameOffsets[nframes], len(buf)),
+		}
+		return nil, errors.WithStack(err)
+	}
+	// check frame offsets; frames must be contiguous and within the bounds of
+	// the file.
+	for i := 0; i < nframes; i++ {
+		frameStartOffset := frameOffsets[i]
+		frameEndOffset := frameOffsets[i+1]
+		switch {
+		case frameEndOffset > uint32(len(buf)):
+			err := &FrameError{
+				Index:  i,
+				Offset: int64(frameStartOffset),
+				Reason: fmt.Sprintf("end offset %d exceeds file size %d", frameEndOffset, len(buf)),
+				Err:    ErrTruncated,
+			}
+			return nil, errors.WithStack(err)
+		case frameStartOffset > frameEndOffset:
+			return nil, errors.WithStack(invalidFrameOffset(i, frameStartOffset, frameEndOffset))
+		}
+	}
+	return frameOffsets, nil
+}
+
+// invalidFrameOffset returns a FrameError for the i:th frame, whose end offset
+// is less than its start offset.
+func invalidFrameOffset(i int, frameStartOffset, frameEndOffset uint32) *FrameError {
+	return &FrameError{
+		Index:  i,
+		Offset: int64(frameStartOffset),
+		Reason: fmt.Sprintf("invalid end offset; expected >= %d, got %d", frameStartOffset, frameEndOffset),
+	}
+}
+
+// ZEL frame header size in bytes.
+const frameHdrSize = 4
+
+// parseFrameHeader parses the frame header of the given non-empty contents of
+// the i:th ZEL frame, located at the specified offset, and returns the frame
+// dimensions.
+func parseFrameHeader(frameContents []byte, i int, frameOffset int64) (width, height int, err error) {
+	if len(frameContents) < frameHdrSize {
+		err := &FrameError{
+			Index:  i,
+			Offset: frameOffset,
+			Reason: fmt.Sprintf("too short frame header; expected >= %d bytes, got %d", frameHdrSize, len(frameContents)),
+			Err:    ErrTruncated,
+		}
+		return 0, 0, errors.WithStack(err)
+	}
+	width = int(binary.LittleEndian.Uint16(frameContents[0:2]))
+	height = int(binary.LittleEndian.Uint16(frameContents[2:4]))
+	// sanity check.
+	switch {
+	case width == 0 || height == 0:
+		err := &FrameError{
+			Index:  i,
+			Offset: frameOffset,
+			Reason: fmt.Sprintf("invalid frame dimensions %dx%d", width, height),
+			Err:    ErrBadDimensions,
+		}
+		return 0, 0, errors.WithStack(err)
+	case width > maxFrameWidth || height > maxFrameHeight:
+		err := &FrameError{
+			Index:  i,
+			Offset: frameOffset,
+			Reason: fmt.Sprintf("frame dimensions %dx%d exceed %dx%d", width, height, maxFrameWidth, maxFrameHeight),
+			Err:    ErrFrameTooLarge,
+		}
+		return 0, 0, errors.WithStack(err)
+	}
+	return width, height, nil
+}
+
+// validateFrame validates the structure of the given non-empty contents of the
+// i:th ZEL frame, located at the specified offset; using the same bounds as
+// parseFrame.
+func validateFrame(frameContents []byte, i int, frameOffset int64, type4 bool) error {
+	// frameErr returns a FrameError at the given position of the frame
+	// contents.
+	frameErr := func(pos int, err error, format string, args ...interface{}) error {
+		e := &FrameError{
+			Index:  i,
+			Offset: frameOffset + int64(pos),
+			Reason: fmt.Sprintf(format, args...),
+			Err:    err,
+		}
+		return errors.WithStack(e)
+	}
+	frameWidth, frameHeight, err := parseFrameHeader(frameContents, i, frameOffset)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	data := frameContents[frameHdrSize:]
+	total := 0
+	for pos := 0; ; {
+		cmdPos := frameHdrSize + pos
+		if pos+2 > len(data) {
+			return frameErr(cmdPos, ErrTruncated, "unterminated RLE command stream")
+		}
+		cmd := binary.LittleEndian.Uint16(data[pos : pos+2])
+		pos += 2
+		if cmd == 0 {
+			return nil
+		}
+		n := int(cmd & 0xFFF)
+		switch {
+		case cmd&0x4000 != 0:
+			// transparent lines.
+			if n > frameHeight {
+				return frameErr(cmdPos, ErrBadCommand, "invalid ySkip (%d); exceeds frame height (%d)", n, frameHeight)
+			}
+			total += n * frameWidth
+		case cmd&0x1000 != 0:
+			// regular (or constant) pixels.
+			if n > frameWidth {
+				return frameErr(cmdPos, ErrBadCommand, "invalid npixels (%d); exceeds frame width (%d)", n, frameWidth)
+			}
+			if !type4 {
+				pos += n
+				if pos > len(data) {
+					return frameErr(cmdPos, ErrTruncated, "pixel data of %d pixels exceeds frame contents", n)
+				}
+			}
+			total += n
+		default:
+			// transparent pixels.
+			if n > frameWidth {
+				return frameErr(cmdPos, ErrBadCommand, "invalid xSkip (%d); exceeds frame width (%d)", n, frameWidth)
+			}
+			total += n
+		}
+		if total > frameWidth*frameHeight {
+			return frameErr(cmdPos, ErrBadCommand, "total pixels (%d) exceeds frame size (%dx%d)", total, frameWidth, frameHeight)
+		}
+		if cmd&0x8000 != 0 && total%frameWidth != 0 {
+			return frameErr(cmdPos, ErrBadCommand, "unexpected end of line at x=%d; expected x=0", total%frameWidth)
+		}
+	}
+}
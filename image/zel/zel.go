@@ -2,21 +2,17 @@
 package zel
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
 
-	"github.com/kr/pretty"
-	"github.com/mewkiz/pkg/imgutil"
 	"github.com/mewkiz/pkg/term"
 	"github.com/pkg/errors"
 )
@@ -36,89 +32,199 @@ var (
 //    height uint16
 //    data   []byte
 
+// Options specifies how to decode ZEL images.
+type Options struct {
+	// Palette used to colour pixels; DefaultPalette is used if nil.
+	Palette color.Palette
+	// Shadow specifies whether to decode the image as a tileset shadows image
+	// (type 4), whose pixel runs use the constant palette index 8 and contain no
+	// pixel data.
+	Shadow bool
+	// Paletted specifies whether to decode frames as *image.Paletted images,
+	// which keep the original palette indices of pixels. Transparent pixels use
+	// the reserved palette index TransparentIndex (see FramePalette).
+	Paletted bool
+	// TransparentIndex is the palette index of transparent pixels of paletted
+	// frames; DefaultTransparentIndex is used if nil. Frames whose pixel data
+	// use the reserved index fail to decode with ErrReservedIndex.
+	TransparentIndex *uint8
+	// OnFrameError, if non-nil, is invoked with the partially decoded frame of
+	// each frame which fails to decode; e.g. to write the partial frame to a
+	// PNG file for debugging.
+	OnFrameError func(index int, partial image.Image, err error)
+}
+
+// DefaultTransparentIndex is the default palette index of transparent pixels of
+// paletted frames.
+const DefaultTransparentIndex = 255
+
+// transparentIndex returns the palette index of transparent pixels of paletted
+// frames.
+func (opts *Options) transparentIndex() uint8 {
+	if opts.TransparentIndex == nil {
+		return DefaultTransparentIndex
+	}
+	return *opts.TransparentIndex
+}
+
 // DecodeAll decodes the given ZEL image using colours from the provided
-// palette, and returns the sequential frames.
-func DecodeAll(zelPath string, pal color.Palette) (imgs []image.Image, err error) {
-	var (
-		curFrame int
-		nframes  int
-	)
-	defer func() {
-		if e := recover(); e != nil {
-			err = errors.Errorf("recovered panic in zel.DecodeAll for frame (%d/%d) of %q: %+v", curFrame, nframes, zelPath, e)
-		}
-	}()
+// palette, and returns the sequential frames. Tileset shadows images are
+// identified by their path (e.g. "X/tilesets/tileset_1_shadows.zel").
+func DecodeAll(zelPath string, pal color.Palette) ([]image.Image, error) {
 	buf, err := ioutil.ReadFile(zelPath)
 	if err != nil {
-		return imgs, errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 	dbg.Printf("parsing %q", zelPath)
-	// parse ZEL header.
-	zelHdrSize := int(binary.LittleEndian.Uint32(buf[0:4]))
-	r := bytes.NewReader(buf)
-	zelHdrReader := io.NewSectionReader(r, 0, int64(zelHdrSize))
-	frameOffsetsLen := zelHdrSize / 4
-	frameOffsets := make([]uint32, frameOffsetsLen)
-	if err := binary.Read(zelHdrReader, binary.LittleEndian, &frameOffsets); err != nil {
+	opts := &Options{
+		Palette: pal,
+		Shadow:  IsShadowPath(zelPath),
+	}
+	imgs, err := DecodeBytes(buf, opts)
+	if err != nil {
+		return imgs, errors.Wrapf(err, "unable to decode %q", zelPath)
+	}
+	return imgs, nil
+}
+
+// Decode decodes the ZEL image read from r using the given options (may be
+// nil), and returns the sequential frames.
+func Decode(r io.Reader, opts *Options) ([]image.Image, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	nframes = len(frameOffsets) - 1
-	if len(buf) != int(frameOffsets[nframes]) {
-		pretty.Println("frameOffsets:", frameOffsets)
-		panic(fmt.Errorf("mismatch between frameOffsets[%d]=%d and len(buf)=%d", nframes, frameOffsets[nframes], len(buf)))
+	return DecodeBytes(buf, opts)
+}
+
+// DecodeBytes decodes the given ZEL image contents using the given options (may
+// be nil), and returns the sequential frames. On error, the frames decoded
+// before the invalid frame are returned.
+func DecodeBytes(buf []byte, opts *Options) ([]image.Image, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
 	}
+	if o.Palette == nil {
+		o.Palette = DefaultPalette
+	}
+	// parse ZEL header.
+	frameOffsets, err := parseZelHeader(buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	nframes := len(frameOffsets) - 1
 	// output ZEL frames.
-	for curFrame = 0; curFrame < nframes; curFrame++ {
-		frameStartOffset := frameOffsets[curFrame]
-		frameEndOffset := frameOffsets[curFrame+1]
+	var imgs []image.Image
+	for i := 0; i < nframes; i++ {
+		frameStartOffset := frameOffsets[i]
+		frameEndOffset := frameOffsets[i+1]
 		frameContents := buf[frameStartOffset:frameEndOffset:frameEndOffset]
-		type4 := isType4(zelPath)
-		img, ok := parseFrame(frameContents, pal, type4)
-		if !ok {
-			//warn.Printf("skipping invalid frame (%d/%d) of %q", curFrame, nframes, zelPath)
-			//continue // skip
-			return imgs, errors.Errorf("unable to decode frame (%d/%d) of %q", curFrame, nframes, zelPath)
+		img, err := parseFrame(frameContents, i, int64(frameStartOffset), &o)
+		if err != nil {
+			if o.OnFrameError != nil && img != nil {
+				o.OnFrameError(i, img, err)
+			}
+			return imgs, errors.WithStack(err)
 		}
 		imgs = append(imgs, img)
 	}
-	return imgs, errors.WithStack(err)
+	return imgs, nil
+}
+
+// SplitFrames splits the given ZEL image contents, returning the contents of
+// the sequential frames. The returned frame contents share memory with buf.
+func SplitFrames(buf []byte) ([][]byte, error) {
+	frameOffsets, err := parseZelHeader(buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	nframes := len(frameOffsets) - 1
+	var framesContents [][]byte
+	for i := 0; i < nframes; i++ {
+		frameStartOffset := frameOffsets[i]
+		frameEndOffset := frameOffsets[i+1]
+		frameContents := buf[frameStartOffset:frameEndOffset:frameEndOffset]
+		framesContents = append(framesContents, frameContents)
+	}
+	return framesContents, nil
+}
+
+// FramePalette returns the palette of paletted frames decoded using colours
+// from the given palette; a copy of the palette in which the colour of the
+// reserved palette index of transparent pixels is color.Transparent. The
+// palette of decoded frames may be replaced later, as long as the reserved
+// index remains transparent.
+func FramePalette(pal color.Palette, transparentIndex uint8) color.Palette {
+	n := len(pal)
+	if n <= int(transparentIndex) {
+		n = int(transparentIndex) + 1
+	}
+	p := make(color.Palette, n)
+	copy(p, pal)
+	for i := len(pal); i < n; i++ {
+		p[i] = color.Black
+	}
+	p[transparentIndex] = color.Transparent
+	return p
 }
 
-// parseFrame parses the given ZEL frame contents.
-func parseFrame(frameContents []byte, pal color.Palette, type4 bool) (image.Image, bool) {
+// parseFrame parses the given contents of the i:th ZEL frame, located at the
+// specified offset, using the given options (with non-nil palette). On error,
+// the partially decoded frame is returned if the frame dimensions are valid.
+func parseFrame(frameContents []byte, i int, frameOffset int64, opts *Options) (image.Image, error) {
 	// parse ZEL frame.
 	if len(frameContents) == 0 {
-		warn.Printf("empty frame")
-		return image.NewRGBA(image.Rect(0, 0, 1, 1)), true // dummy 1x1 image used for empty frames
+		// dummy 1x1 image used for empty frames
+		dst, _ := newCanvas(1, 1, opts)
+		return dst, nil
 	}
-	frameWidth := int(binary.LittleEndian.Uint16(frameContents[0:2]))
-	frameHeight := int(binary.LittleEndian.Uint16(frameContents[2:4]))
-	// sanity check.
-	// NOTE: 650 is a valid width of `archive_0012/archive_0005/frame_0000.png`.
-	// NOTE: 640 is a valid height of `archive_0012/archive_0001/frame_0165.png`.
-	// NOTE: 1037 is a valid width of `archive_0012/archive_0040/frame_0034.png`.
-	if frameWidth == 0 || frameHeight == 0 || frameWidth > 1280 || frameHeight > 1280 {
-		warn.Printf("sanity check failed; frameWidth=%d, frameHeight=%d\n%s", frameWidth, frameHeight, hex.Dump(frameContents))
-		return nil, false
+	// frameErr returns a FrameError at the given position of the frame
+	// contents.
+	frameErr := func(pos int, err error, format string, args ...interface{}) error {
+		e := &FrameError{
+			Index:  i,
+			Offset: frameOffset + int64(pos),
+			Reason: fmt.Sprintf(format, args...),
+			Err:    err,
+		}
+		return errors.WithStack(e)
 	}
-	dbg.Printf("frame dimensions: %dx%d", frameWidth, frameHeight)
-	bounds := image.Rect(0, 0, frameWidth, frameHeight)
-	dst := image.NewRGBA(bounds)
-
-	// TODO: remove partial_img.png output after broken ZEL images have been
-	// patched. Used now for debugging.
-	defer func() {
-		if e := recover(); e != nil {
-			if err := imgutil.WriteFile("_dump_/partial_img.png", dst); err != nil {
-				panic(err)
-			}
-			warn.Printf("recovered from %+v", e)
+	frameWidth, frameHeight, err := parseFrameHeader(frameContents, i, frameOffset)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	dst, setPixel := newCanvas(frameWidth, frameHeight, opts)
+	// checkIndex validates the palette index of an opaque pixel.
+	checkIndex := func(pos int, palIndex byte) error {
+		switch {
+		case int(palIndex) >= len(opts.Palette):
+			return frameErr(pos, ErrBadCommand, "palette index %d out of range; palette has %d colours", palIndex, len(opts.Palette))
+		case opts.Paletted && palIndex == opts.transparentIndex():
+			return frameErr(pos, ErrReservedIndex, "pixel uses reserved palette index %d of transparent pixels", palIndex)
 		}
-	}()
+		return nil
+	}
 
-	data := frameContents[4:]
-	drawPixel, total := pixelDrawer(dst, frameWidth, frameHeight)
+	data := frameContents[frameHdrSize:]
+	npixels := frameWidth * frameHeight
+	total := 0
+	// drawPixel sets the next pixel to the given palette index, or to
+	// transparent if palIndex is -1. It reports false, without setting the
+	// pixel, once every pixel of the frame has been set.
+	drawPixel := func(palIndex int) bool {
+		if total >= npixels {
+			return false
+		}
+		setPixel(total, palIndex)
+		total++
+		return true
+	}
 	for pos := 0; pos < len(data); {
+		cmdPos := frameHdrSize + pos
+		if pos+2 > len(data) {
+			return dst, frameErr(cmdPos, ErrTruncated, "truncated RLE command")
+		}
 		cmd := binary.LittleEndian.Uint16(data[pos : pos+2])
 		pos += 2
 		//dbg.Printf("cmd: 0x%04X", cmd)
@@ -128,45 +234,54 @@ func parseFrame(frameContents []byte, pal color.Palette, type4 bool) (image.Imag
 			}
 			break
 		}
+		// skip draws n transparent pixels.
+		skip := func(n int) error {
+			for j := 0; j < n; j++ {
+				if !drawPixel(-1) {
+					return frameErr(cmdPos, ErrBadCommand, "total pixels exceeds frame size (%dx%d)", frameWidth, frameHeight)
+				}
+			}
+			return nil
+		}
 		switch {
 		case cmd&0x4000 != 0:
 			// transparent lines.
 			ySkip := int(cmd & 0xFFF)
 			//dbg.Printf("   transparent lines (ySkip=%d)", ySkip)
 			if ySkip > frameHeight {
-				panic(fmt.Errorf("invalid ySkip (%d); exceeds frame width (%d)", ySkip, frameWidth))
+				return dst, frameErr(cmdPos, ErrBadCommand, "invalid ySkip (%d); exceeds frame height (%d)", ySkip, frameHeight)
 			}
-			skip := ySkip * frameWidth
-			for j := 0; j < skip; j++ {
-				drawPixel(color.Transparent)
+			if err := skip(ySkip * frameWidth); err != nil {
+				return dst, errors.WithStack(err)
 			}
 		case cmd&0x1000 != 0:
 			// regular pixels.
-			npixels := int(cmd & 0xFFF)
-			switch {
-			case type4:
-				// Tileset shadows (using constant palette index 8).
-				//
-				//    "X/tilesets/archive_NNNN.zel" where (NNNN%4 == 0)
-				//dbg.Printf("   constant pixels (npixels=%d)", npixels)
-				if npixels > frameWidth {
-					panic(fmt.Errorf("invalid npixels (%d); exceeds frame width (%d)", npixels, frameWidth))
-				}
-				for j := 0; j < npixels; j++ {
-					const palIndex = 8
+			n := int(cmd & 0xFFF)
+			if n > frameWidth {
+				return dst, frameErr(cmdPos, ErrBadCommand, "invalid npixels (%d); exceeds frame width (%d)", n, frameWidth)
+			}
+			for j := 0; j < n; j++ {
+				var palIndex byte
+				switch {
+				case opts.Shadow:
+					// Tileset shadows (using constant palette index 8).
+					//
+					//    "X/tilesets/archive_NNNN.zel" where (NNNN%4 == 0)
+					palIndex = 8
 					//dbg.Printf("      constant pixel 0x%02X", palIndex)
-					drawPixel(pal[palIndex])
-				}
-			default:
-				//dbg.Printf("   regular pixels (npixels=%d)", npixels)
-				if npixels > frameWidth {
-					panic(fmt.Errorf("invalid npixels (%d); exceeds frame width (%d)", npixels, frameWidth))
-				}
-				for j := 0; j < npixels; j++ {
-					palIndex := data[pos]
+				default:
+					if pos >= len(data) {
+						return dst, frameErr(cmdPos, ErrTruncated, "pixel data of %d pixels exceeds frame contents", n)
+					}
+					palIndex = data[pos]
 					//dbg.Printf("      regular pixel 0x%02X", palIndex)
 					pos++
-					drawPixel(pal[palIndex])
+				}
+				if err := checkIndex(cmdPos, palIndex); err != nil {
+					return dst, errors.WithStack(err)
+				}
+				if !drawPixel(int(palIndex)) {
+					return dst, frameErr(cmdPos, ErrBadCommand, "total pixels exceeds frame size (%dx%d)", frameWidth, frameHeight)
 				}
 			}
 		default:
@@ -174,63 +289,72 @@ func parseFrame(frameContents []byte, pal color.Palette, type4 bool) (image.Imag
 			xSkip := int(cmd & 0xFFF)
 			//dbg.Printf("   transparent pixels (xSkip=%d)", xSkip)
 			if xSkip > frameWidth {
-				panic(fmt.Errorf("invalid xSkip (%d); exceeds frame width (%d)", xSkip, frameWidth))
+				return dst, frameErr(cmdPos, ErrBadCommand, "invalid xSkip (%d); exceeds frame width (%d)", xSkip, frameWidth)
 			}
-			skip := xSkip
-			for j := 0; j < skip; j++ {
-				drawPixel(color.Transparent)
+			if err := skip(xSkip); err != nil {
+				return dst, errors.WithStack(err)
 			}
 		}
 		if cmd&0x8000 != 0 {
-			// clear line.
-			skip := (frameWidth - *total) % frameWidth // TODO: double-check that this is correct
-			//dbg.Printf("   clear line (skip=%d)", skip)
-			if skip != 0 {
-				panic(fmt.Errorf("unexpected clear line skip; expected 0, got %d", skip))
-			}
-			for j := 0; j < skip; j++ {
-				drawPixel(color.Transparent)
+			// end of line.
+			if x := total % frameWidth; x != 0 {
+				return dst, frameErr(cmdPos, ErrBadCommand, "unexpected end of line at x=%d; expected x=0", x)
 			}
 		}
 	}
-	if *total > frameWidth*frameHeight {
-		panic(fmt.Errorf("mismatch between total pixels drawn (%d) and expected image size (%dx%d = %d)", *total, frameWidth, frameHeight, frameWidth*frameHeight))
-	}
-	return dst, true
+	return dst, nil
 }
 
-// pixelDrawer returns a function which may be invoked to incrementally set
-// pixels; starting in the lower left corner, going from left to right, and then
-// row by row from the bottom to the top of the image.
-func pixelDrawer(dst draw.Image, w, h int) (func(color.Color), *int) {
-	total := 0
-	x, y := 0, 0
-	return func(c color.Color) {
-		// TODO: Remove sanity check once the zel decoder library has mature.
-		if x < 0 || x >= w {
-			panic(fmt.Sprintf("zel.pixelDrawer.drawPixel: invalid x; expected 0 <= x < %d, got x=%d", w, x))
+// newCanvas returns a transparent frame image of the given dimensions, and a
+// function which may be invoked to set the n:th pixel of the image (in row-major
+// order) to the given palette index, or to transparent if palIndex is -1. The
+// frame is an *image.Paletted if opts.Paletted is set, and an *image.RGBA
+// otherwise.
+func newCanvas(w, h int, opts *Options) (image.Image, func(n, palIndex int)) {
+	bounds := image.Rect(0, 0, w, h)
+	if opts.Paletted {
+		ti := opts.transparentIndex()
+		dst := image.NewPaletted(bounds, FramePalette(opts.Palette, ti))
+		for i := range dst.Pix {
+			dst.Pix[i] = ti
 		}
-		if y < 0 || y >= h {
-			panic(fmt.Sprintf("zel.pixelDrawer.drawPixel: invalid y; expected 0 <= y < %d, got y=%d", h, y))
+		setPixel := func(n, palIndex int) {
+			if palIndex == -1 {
+				dst.Pix[n] = ti
+				return
+			}
+			dst.Pix[n] = uint8(palIndex)
 		}
-		total++
-		dst.Set(x, y, c)
-		x++
-		if x >= w {
-			x = 0
-			y++
+		return dst, setPixel
+	}
+	dst := image.NewRGBA(bounds)
+	// convert palette once, rather than for each pixel.
+	colors := make([]color.RGBA, len(opts.Palette))
+	for i, c := range opts.Palette {
+		colors[i] = color.RGBAModel.Convert(c).(color.RGBA)
+	}
+	setPixel := func(n, palIndex int) {
+		pix := dst.Pix[n*4 : n*4+4 : n*4+4]
+		if palIndex == -1 {
+			pix[0], pix[1], pix[2], pix[3] = 0, 0, 0, 0
+			return
 		}
-	}, &total
+		c := colors[palIndex]
+		pix[0], pix[1], pix[2], pix[3] = c.R, c.G, c.B, c.A
+	}
+	return dst, setPixel
 }
 
-// isType4 reports whether the given ZEL is a type 4 tileset ZEL image (used for
-// tileset shadows).
-func isType4(zelPath string) bool {
+// IsShadowPath reports whether the given ZEL image path is that of a type 4
+// tileset ZEL image (used for tileset shadows); e.g.
+// "_dump_/X/tilesets/tileset_1_shadows.zel".
+func IsShadowPath(zelPath string) bool {
 	zelPath = strings.ReplaceAll(zelPath, `\`, "/")
 	const rootDir = "X/"
 	pos := strings.Index(zelPath, rootDir)
 	if pos == -1 {
-		panic(fmt.Errorf("unable to find root directory %q in %q", rootDir, zelPath))
+		// not located within the archive tree of X.PAK.
+		return false
 	}
 	zelPath = zelPath[pos:]
 	return isType4TilesetZel[zelPath]
@@ -0,0 +1,40 @@
+package maps
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Underlying errors of FieldError, which may be tested using errors.Is.
+var (
+	// ErrBadSignature reports a MAP file without the "MAP\x00" signature.
+	ErrBadSignature = errors.New("invalid MAP signature")
+	// ErrTruncated reports a MAP file too short to hold its contents.
+	ErrTruncated = errors.New("truncated MAP file")
+)
+
+// FieldError reports an invalid field of a MAP file.
+type FieldError struct {
+	// Field name (e.g. "Magic" or "nbuildings").
+	Field string
+	// Offset in bytes of the field within the MAP file.
+	Offset int64
+	// Description of the problem; the underlying error is used if empty.
+	Reason string
+	// Underlying error (e.g. ErrBadSignature).
+	Err error
+}
+
+// Error returns the error message.
+func (e *FieldError) Error() string {
+	reason := e.Reason
+	if len(reason) == 0 && e.Err != nil {
+		reason = e.Err.Error()
+	}
+	return fmt.Sprintf("invalid MAP field %s at offset %d: %s", e.Field, e.Offset, reason)
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
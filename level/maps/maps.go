@@ -4,6 +4,8 @@ package maps
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -101,44 +103,88 @@ func ParseFile(mapPath string) (*Map, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	m, err := Parse(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse MAP file %q", mapPath)
+	}
+	return m, nil
+}
+
+// Parse parses the given MAP file contents.
+func Parse(buf []byte) (*Map, error) {
 	r := bytes.NewReader(buf)
+	// read reads the given field from r.
+	read := func(field string, data interface{}) error {
+		offset := r.Size() - int64(r.Len())
+		if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = ErrTruncated
+			}
+			return errors.WithStack(&FieldError{Field: field, Offset: offset, Err: err})
+		}
+		return nil
+	}
+	// checkCount checks that the remaining contents of r hold n elements of the
+	// given field.
+	checkCount := func(field string, n uint32, elem interface{}) error {
+		offset := r.Size() - int64(r.Len())
+		if size := int64(n) * int64(binary.Size(elem)); size > int64(r.Len()) {
+			err := &FieldError{
+				Field:  field,
+				Offset: offset,
+				Reason: fmt.Sprintf("%d elements (%d bytes) exceed remaining %d bytes", n, size, r.Len()),
+				Err:    ErrTruncated,
+			}
+			return errors.WithStack(err)
+		}
+		return nil
+	}
 	m := &Map{}
-	if err := binary.Read(r, binary.LittleEndian, &m.Magic); err != nil {
+	if err := read("Magic", &m.Magic); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	magic := string(m.Magic[:])
 	if magic != signature {
-		return nil, errors.Errorf("invalid MAP signature of %q; expected %q, got %q", mapPath, signature, magic)
+		err := &FieldError{
+			Field:  "Magic",
+			Offset: 0,
+			Reason: fmt.Sprintf("invalid MAP signature; expected %q, got %q", signature, magic),
+			Err:    ErrBadSignature,
+		}
+		return nil, errors.WithStack(err)
 	}
 	//dbg.Println("magic:", magic)
-	if err := binary.Read(r, binary.LittleEndian, &m.Unused0004); err != nil {
+	if err := read("Unused0004", &m.Unused0004); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Printf("m.Unused0004: 0x%08X", m.Unused0004)
-	if err := binary.Read(r, binary.LittleEndian, &m.RenderWithLight); err != nil {
+	if err := read("RenderWithLight", &m.RenderWithLight); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Printf("m.RenderWithLight: 0x%02X", m.RenderWithLight)
-	if err := binary.Read(r, binary.LittleEndian, &m.BaseWallsTilesetID); err != nil {
+	if err := read("BaseWallsTilesetID", &m.BaseWallsTilesetID); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Printf("m.BaseWallsTilesetID: 0x%02X", m.BaseWallsTilesetID)
-	if err := binary.Read(r, binary.LittleEndian, &m.SolidMap); err != nil {
+	if err := read("SolidMap", &m.SolidMap); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Printf("m.SolidMap:\n%v", m.SolidMap)
 	// Floors.
-	if err := binary.Read(r, binary.LittleEndian, &m.FloorFrameMap); err != nil {
+	if err := read("FloorFrameMap", &m.FloorFrameMap); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Printf("m.FloorFrameMap:\n%v", m.FloorFrameMap)
 	// Tileset 0 (backgrounds).
 	var nbackgrounds uint32
-	if err := binary.Read(r, binary.LittleEndian, &nbackgrounds); err != nil {
+	if err := read("nbackgrounds", &nbackgrounds); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := checkCount("Backgrounds", nbackgrounds, MapOverlay{}); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	m.Backgrounds = make([]MapOverlay, int(nbackgrounds))
-	if err := binary.Read(r, binary.LittleEndian, &m.Backgrounds); err != nil {
+	if err := read("Backgrounds", &m.Backgrounds); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Println("m.Backgrounds (stairs and mountains):")
@@ -147,11 +193,14 @@ func ParseFile(mapPath string) (*Map, error) {
 	//}
 	// Tileset 4 (shadows).
 	var nshadows uint32
-	if err := binary.Read(r, binary.LittleEndian, &nshadows); err != nil {
+	if err := read("nshadows", &nshadows); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := checkCount("Shadows", nshadows, MapOverlay{}); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	m.Shadows = make([]MapOverlay, int(nshadows))
-	if err := binary.Read(r, binary.LittleEndian, &m.Shadows); err != nil {
+	if err := read("Shadows", &m.Shadows); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Println("m.Shadows:")
@@ -160,11 +209,14 @@ func ParseFile(mapPath string) (*Map, error) {
 	//}
 	// Tileset 1 (buildings).
 	var nbuildings uint32
-	if err := binary.Read(r, binary.LittleEndian, &nbuildings); err != nil {
+	if err := read("nbuildings", &nbuildings); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := checkCount("Buildings", nbuildings, MapTile{}); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	m.Buildings = make([]MapTile, int(nbuildings))
-	if err := binary.Read(r, binary.LittleEndian, &m.Buildings); err != nil {
+	if err := read("Buildings", &m.Buildings); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Println("m.Buildings:")
@@ -173,11 +225,14 @@ func ParseFile(mapPath string) (*Map, error) {
 	//}
 	// Tileset 3 (objects).
 	var nobjects uint32
-	if err := binary.Read(r, binary.LittleEndian, &nobjects); err != nil {
+	if err := read("nobjects", &nobjects); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := checkCount("Objects", nobjects, MapTile{}); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	m.Objects = make([]MapTile, int(nobjects))
-	if err := binary.Read(r, binary.LittleEndian, &m.Objects); err != nil {
+	if err := read("Objects", &m.Objects); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Println("m.Objects:")
@@ -186,11 +241,14 @@ func ParseFile(mapPath string) (*Map, error) {
 	//}
 	// Base walls.
 	var nbaseWalls uint32
-	if err := binary.Read(r, binary.LittleEndian, &nbaseWalls); err != nil {
+	if err := read("nbaseWalls", &nbaseWalls); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := checkCount("BaseWalls", nbaseWalls, MapTile{}); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	m.BaseWalls = make([]MapTile, int(nbaseWalls))
-	if err := binary.Read(r, binary.LittleEndian, &m.BaseWalls); err != nil {
+	if err := read("BaseWalls", &m.BaseWalls); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	//dbg.Println("m.BaseWalls:")